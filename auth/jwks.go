@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Refresh intervals of remote key sets. Keys are refetched periodically, and sooner when a token is signed
+// with an unknown key, which is how key rotations are picked up; the minimum interval keeps tokens with
+// made-up key IDs from hammering the provider.
+const (
+	keySetRefreshInterval    = time.Hour
+	keySetMinRefreshInterval = time.Minute
+)
+
+// ErrUnknownKey is returned when a token is signed with a key missing from the key set.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// ErrKeySetUnavailable is returned when the signing key of a token cannot be looked up because the key set
+// cannot be fetched. It says nothing about the token.
+var ErrKeySetUnavailable = errors.New("key set unavailable")
+
+// jsonWebKey is a public key in JSON Web Key form (RFC 7517).
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet is a set of public keys by key ID.
+type KeySet struct {
+	keys map[string]crypto.PublicKey
+}
+
+// ParseKeySet parses a JSON Web Key Set. RSA, EC (P-256, P-384, P-521) and Ed25519 keys are supported;
+// keys of other types, or meant for encryption, are skipped.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("decoding key set: %w", err)
+	}
+
+	set := &KeySet{keys: make(map[string]crypto.PublicKey, len(document.Keys))}
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if errors.Is(err, errUnsupportedKey) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		set.keys[jwk.Kid] = key
+	}
+	return set, nil
+}
+
+// Key returns the key with the given ID. A token without key ID is accepted when the set holds a single key.
+func (s *KeySet) Key(kid string) (crypto.PublicKey, error) {
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+}
+
+// Len returns the number of keys in the set.
+func (s *KeySet) Len() int {
+	return len(s.keys)
+}
+
+// errUnsupportedKey is returned for keys of a type or curve that cannot verify tokens.
+var errUnsupportedKey = errors.New("unsupported key")
+
+// publicKey decodes the key material of a JSON Web Key.
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errUnsupportedKey
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errUnsupportedKey
+	}
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer.
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// RemoteKeySet is a key set fetched over HTTP from a JWKS URL and refreshed as keys rotate.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        *KeySet
+	fetchedAt   time.Time        // Time of the last successful fetch.
+	attemptedAt time.Time        // Time of the last fetch, successful or not.
+	fetchErr    error            // Error of the last fetch.
+	now         func() time.Time // Replaceable in tests.
+}
+
+// NewRemoteKeySet returns a key set fetched from a URL on first use.
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	return &RemoteKeySet{url: url, client: client, now: time.Now}
+}
+
+// Key returns the key with the given ID, fetching the key set when it is stale or does not have the key.
+// While the provider is unreachable, the keys fetched last keep being used; keys they lack, which may have been
+// rotated in meanwhile, return ErrKeySetUnavailable.
+func (r *RemoteKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if r.keys != nil && now.Sub(r.fetchedAt) < keySetRefreshInterval {
+		if key, err := r.keys.Key(kid); err == nil {
+			return key, nil
+		}
+	}
+	if r.attemptedAt.IsZero() || now.Sub(r.attemptedAt) >= keySetMinRefreshInterval {
+		r.attemptedAt = now
+		r.fetchErr = r.refresh(ctx)
+	}
+	if r.keys == nil {
+		return nil, fmt.Errorf("%w: %w", ErrKeySetUnavailable, r.fetchErr)
+	}
+	key, err := r.keys.Key(kid)
+	if err != nil && r.fetchErr != nil {
+		return nil, fmt.Errorf("%w: %w", ErrKeySetUnavailable, r.fetchErr)
+	}
+	return key, err
+}
+
+// refresh fetches the key set. The caller holds the lock.
+func (r *RemoteKeySet) refresh(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("fetching key set: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching key set: %s", response.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("fetching key set: %w", err)
+	}
+	keys, err := ParseKeySet(data)
+	if err != nil {
+		return err
+	}
+	r.keys, r.fetchedAt = keys, r.now()
+	return nil
+}
+
+// keyfunc returns a jwt.Keyfunc looking up the signing key of a token by its key ID.
+func keyfunc(lookup func(kid string) (crypto.PublicKey, error)) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return lookup(kid)
+	}
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// oidcHTTPTimeout bounds the calls made to the OpenID Connect provider.
+const oidcHTTPTimeout = 10 * time.Second
+
+// signingMethods are the algorithms accepted for tokens signed by the provider.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ErrInvalidToken is returned for tokens that are malformed, badly signed, expired or meant for someone else.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Identity is what the provider asserts about a user in an ID token or access token.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+}
+
+// ProviderConfig configures an OpenID Connect provider.
+type ProviderConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	Audience     string // Audience required in bearer tokens.
+	GroupsClaim  string
+}
+
+// Provider logs users in with an OpenID Connect provider through the authorization code flow with PKCE,
+// and verifies the ID tokens and bearer tokens it issues. The provider's endpoints are discovered on first use.
+type Provider struct {
+	config ProviderConfig
+	client *http.Client
+
+	mu     sync.Mutex
+	oauth  *oauth2.Config
+	keys   *RemoteKeySet
+	issuer string // Issuer as spelled by the provider's metadata, which tokens must match exactly.
+}
+
+// discoveryDocument is the part of the provider's metadata (OpenID Connect Discovery 1.0) used here.
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider is the provider configured by InitializeOIDC, or nil when single sign-on is disabled.
+var oidcProvider *Provider
+
+// InitializeOIDC sets up single sign-on from the configuration, if an issuer is configured.
+func InitializeOIDC() {
+	if config.AppConfig.OIDCIssuerURL == "" {
+		return
+	}
+	oidcProvider = NewProvider(ProviderConfig{
+		IssuerURL:    config.AppConfig.OIDCIssuerURL,
+		ClientID:     config.AppConfig.OIDCClientID,
+		ClientSecret: config.AppConfig.OIDCClientSecret,
+		RedirectURL:  config.AppConfig.OIDCRedirectURL,
+		Scopes:       config.AppConfig.OIDCScopes,
+		Audience:     config.AppConfig.OIDCAudience,
+		GroupsClaim:  config.AppConfig.OIDCGroupsClaim,
+	}, &http.Client{Timeout: oidcHTTPTimeout})
+}
+
+// OIDC returns the configured OpenID Connect provider, or nil when single sign-on is disabled.
+func OIDC() *Provider {
+	return oidcProvider
+}
+
+// NewProvider returns a provider using the given HTTP client to reach it.
+func NewProvider(cfg ProviderConfig, client *http.Client) *Provider {
+	cfg.IssuerURL = strings.TrimSuffix(cfg.IssuerURL, "/")
+	if cfg.Audience == "" {
+		cfg.Audience = cfg.ClientID
+	}
+	return &Provider{config: cfg, client: client}
+}
+
+// Issuer returns the provider's issuer URL.
+func (p *Provider) Issuer() string {
+	return p.config.IssuerURL
+}
+
+// AuthCodeURL returns the URL of the provider's login page. The state is echoed back to the callback,
+// the nonce is embedded in the ID token, and the verifier's challenge binds the code to this login (PKCE).
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	oauth, _, err := p.endpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce)), nil
+}
+
+// Exchange redeems an authorization code and returns the identity asserted by the ID token,
+// after checking the token's signature, issuer, audience, expiry and nonce.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	oauth, keys, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := oauth.Exchange(context.WithValue(ctx, oauth2.HTTPClient, p.client), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchanging authorization code: %w", err)
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token in the token response", ErrInvalidToken)
+	}
+
+	claims, err := p.verify(ctx, keys, rawIDToken, p.config.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	return p.identity(claims), nil
+}
+
+// VerifyBearerToken checks a JWT access token issued by the provider for the configured audience,
+// and returns the identity it asserts.
+func (p *Provider) VerifyBearerToken(ctx context.Context, rawToken string) (*Identity, error) {
+	_, keys, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := p.verify(ctx, keys, rawToken, p.config.Audience)
+	if err != nil {
+		return nil, err
+	}
+	return p.identity(claims), nil
+}
+
+// verify parses a token signed by the provider and checks its issuer, audience and validity period.
+// Tokens failing the checks return ErrInvalidToken; tokens whose key cannot be fetched return ErrKeySetUnavailable.
+func (p *Provider) verify(ctx context.Context, keys *RemoteKeySet, rawToken, audience string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims,
+		keyfunc(func(kid string) (crypto.PublicKey, error) { return keys.Key(ctx, kid) }),
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if errors.Is(err, ErrKeySetUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if sub, _ := claims.GetSubject(); sub == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// identity extracts the identity asserted by verified claims.
+func (p *Provider) identity(claims jwt.MapClaims) *Identity {
+	identity := &Identity{Issuer: p.config.IssuerURL}
+	identity.Subject, _ = claims.GetSubject()
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	if groups, ok := claims[p.config.GroupsClaim].([]any); ok {
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	}
+	return identity
+}
+
+// endpoints returns the OAuth 2.0 configuration and key set of the provider, discovering them on first use.
+// A failed discovery is retried on the next call.
+func (p *Provider) endpoints(ctx context.Context) (*oauth2.Config, *RemoteKeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, p.keys, nil
+	}
+
+	document, err := p.fetchDiscoveryDocument(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Scopes:       p.config.Scopes,
+		Endpoint:     oauth2.Endpoint{AuthURL: document.AuthorizationEndpoint, TokenURL: document.TokenEndpoint},
+	}
+	p.keys = NewRemoteKeySet(document.JWKSURI, p.client)
+	p.issuer = document.Issuer
+	return p.oauth, p.keys, nil
+}
+
+// fetchDiscoveryDocument fetches the provider's metadata and checks it belongs to the configured issuer.
+func (p *Provider) fetchDiscoveryDocument(ctx context.Context) (*discoveryDocument, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.IssuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("fetching provider metadata: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching provider metadata: %s", response.Status)
+	}
+
+	var document discoveryDocument
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&document); err != nil {
+		return nil, fmt.Errorf("decoding provider metadata: %w", err)
+	}
+	if strings.TrimSuffix(document.Issuer, "/") != p.config.IssuerURL {
+		return nil, fmt.Errorf("provider metadata is for issuer %q, not %q", document.Issuer, p.config.IssuerURL)
+	}
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JWKSURI == "" {
+		return nil, errors.New("provider metadata lacks an authorization, token or JWKS endpoint")
+	}
+	return &document, nil
+}
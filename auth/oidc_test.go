@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIssuer is a local stand-in for an OpenID Connect provider. It serves discovery, a JWKS and a token
+// endpoint that redeems the codes it was told about, checking their PKCE verifier.
+type testIssuer struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	keys   map[string]*rsa.PrivateKey
+	signer string                   // Key ID used to sign tokens.
+	codes  map[string]jwt.MapClaims // Claims of the ID token returned for each code.
+	pkce   map[string]string        // Code challenge expected for each code.
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	issuer := &testIssuer{keys: map[string]*rsa.PrivateKey{}, codes: map[string]jwt.MapClaims{}, pkce: map[string]string{}}
+	issuer.rotate(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		var keys []map[string]string
+		for kid, key := range issuer.keys {
+			keys = append(keys, map[string]string{
+				"kid": kid, "kty": "RSA", "use": "sig",
+				"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		code := r.PostForm.Get("code")
+		issuer.mu.Lock()
+		claims, ok := issuer.codes[code]
+		challenge := issuer.pkce[code]
+		delete(issuer.codes, code)
+		issuer.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "opaque", "token_type": "Bearer", "expires_in": 3600,
+			"id_token": issuer.sign(t, claims),
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// rotate adds a signing key and signs the next tokens with it.
+func (i *testIssuer) rotate(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keys[kid], i.signer = key, kid
+}
+
+// sign returns a token with the given claims, and the issuer and validity period unless set.
+func (i *testIssuer) sign(t *testing.T, claims jwt.MapClaims) string {
+	full := jwt.MapClaims{"iss": i.server.URL, "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()}
+	for name, value := range claims {
+		full[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, full)
+	token.Header["kid"] = i.signer
+	signed, err := token.SignedString(i.keys[i.signer])
+	require.NoError(t, err)
+	return signed
+}
+
+// authorize simulates a user logging in at the authorization URL: the code it returns redeems an ID token
+// with the given claims, for the PKCE challenge and nonce of the URL.
+func (i *testIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	claims["nonce"] = query.Get("nonce")
+	claims["aud"] = query.Get("client_id")
+
+	code := "code-" + query.Get("state")
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.codes[code], i.pkce[code] = claims, query.Get("code_challenge")
+	return code
+}
+
+func newTestProvider(issuer *testIssuer) *Provider {
+	return NewProvider(ProviderConfig{
+		IssuerURL:   issuer.server.URL,
+		ClientID:    "shortener",
+		RedirectURL: "http://localhost/callback",
+		Scopes:      []string{"openid", "email"},
+		Audience:    "shortener-api",
+		GroupsClaim: "groups",
+	}, issuer.server.Client())
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(issuer)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-that-is-long-enough-for-pkce-43-chars")
+	require.NoError(t, err)
+	code := issuer.authorize(t, authURL, jwt.MapClaims{"sub": "user-1", "email": "ada@example.com", "email_verified": true, "groups": []string{"marketing"}})
+
+	// A wrong verifier is refused by the provider.
+	_, err = provider.Exchange(ctx, code, "nonce-1", "another-verifier-that-is-long-enough-for-pkce")
+	assert.Error(t, err)
+
+	code = issuer.authorize(t, authURL, jwt.MapClaims{"sub": "user-1", "email": "ada@example.com", "email_verified": true, "groups": []string{"marketing"}})
+	identity, err := provider.Exchange(ctx, code, "nonce-1", "verifier-that-is-long-enough-for-pkce-43-chars")
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Issuer: issuer.server.URL, Subject: "user-1", Email: "ada@example.com", EmailVerified: true, Groups: []string{"marketing"}}, identity)
+
+	// The ID token must carry the nonce of the login.
+	code = issuer.authorize(t, authURL, jwt.MapClaims{"sub": "user-1"})
+	_, err = provider.Exchange(ctx, code, "other-nonce", "verifier-that-is-long-enough-for-pkce-43-chars")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestOIDCBearerToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(issuer)
+	ctx := context.Background()
+
+	identity, err := provider.VerifyBearerToken(ctx, issuer.sign(t, jwt.MapClaims{"sub": "service-1", "aud": "shortener-api"}))
+	require.NoError(t, err)
+	assert.Equal(t, "service-1", identity.Subject)
+
+	for name, claims := range map[string]jwt.MapClaims{
+		"wrong audience": {"sub": "service-1", "aud": "another-api"},
+		"wrong issuer":   {"sub": "service-1", "aud": "shortener-api", "iss": "https://evil.example"},
+		"expired":        {"sub": "service-1", "aud": "shortener-api", "exp": time.Now().Add(-time.Hour).Unix()},
+		"no subject":     {"aud": "shortener-api"},
+	} {
+		_, err := provider.VerifyBearerToken(ctx, issuer.sign(t, claims))
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	// Tokens signed with a new key are accepted once the key set is refetched.
+	provider.keys.now = func() time.Time { return time.Now().Add(2 * keySetMinRefreshInterval) }
+	issuer.rotate(t, "key-2")
+	_, err = provider.VerifyBearerToken(ctx, issuer.sign(t, jwt.MapClaims{"sub": "service-1", "aud": "shortener-api"}))
+	assert.NoError(t, err)
+
+	// While the provider is unreachable, tokens signed with keys it never served are neither accepted nor refused.
+	issuer.rotate(t, "key-3")
+	token := issuer.sign(t, jwt.MapClaims{"sub": "service-1", "aud": "shortener-api"})
+	issuer.server.Close()
+	provider.keys.now = func() time.Time { return time.Now().Add(4 * keySetMinRefreshInterval) }
+	_, err = provider.VerifyBearerToken(ctx, token)
+	assert.ErrorIs(t, err, ErrKeySetUnavailable)
+	assert.NotErrorIs(t, err, ErrInvalidToken)
+}
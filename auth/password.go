@@ -0,0 +1,44 @@
+// Package auth holds the authentication primitives shared by the HTTP handlers.
+package auth
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password length limits. bcrypt ignores everything after 72 bytes, so longer passwords are refused
+// rather than silently truncated.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// ErrInvalidPassword is returned when a password does not match its hash.
+var ErrInvalidPassword = errors.New("invalid password")
+
+// dummyHash is compared against when a login names an unknown user, so the response time
+// does not reveal which email addresses are registered.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// HashPassword returns the bcrypt hash of a password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword returns nil if the password matches the hash, and ErrInvalidPassword otherwise.
+// An empty hash, as for an unknown user, never matches but takes as long to check as a real one.
+func CheckPassword(hash, password string) error {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return ErrInvalidPassword
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return ErrInvalidPassword
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := HashPassword("correct horse battery")
+	require.NoError(t, err)
+	assert.NotEqual(t, "correct horse battery", hash)
+
+	assert.NoError(t, CheckPassword(hash, "correct horse battery"))
+	assert.ErrorIs(t, CheckPassword(hash, "wrong password"), ErrInvalidPassword)
+	assert.ErrorIs(t, CheckPassword("", "correct horse battery"), ErrInvalidPassword)
+	assert.ErrorIs(t, CheckPassword("not a hash", "correct horse battery"), ErrInvalidPassword)
+
+	// Hashes are salted.
+	other, err := HashPassword("correct horse battery")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+}
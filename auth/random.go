@@ -0,0 +1,16 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// RandomToken returns 32 random bytes encoded as a URL-safe string of 43 characters,
+// suitable for OAuth state, nonce and PKCE verifier values.
+func RandomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
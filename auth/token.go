@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes granted by API tokens. Each route of the API requires one of them from token-authenticated requests.
+const (
+	ScopeLinksRead  = "links:read"
+	ScopeLinksWrite = "links:write"
+	ScopeStatsRead  = "stats:read"
+)
+
+// keyFileCheckInterval is how often the JWKS file is checked for changes while tokens are verified.
+const keyFileCheckInterval = 5 * time.Second
+
+// tokenLeeway is the clock skew tolerated when checking the validity period of tokens.
+const tokenLeeway = 30 * time.Second
+
+// TokenClaims are the claims of an API token. The subject owns the links the token manages,
+// and the scopes limit what it can do with them.
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope,omitempty"` // Space-separated scopes, as in RFC 8693.
+}
+
+// HasScope reports whether the token grants a scope.
+func (c *TokenClaims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// TokenVerifier verifies API tokens: HS256 tokens signed with a shared secret, and RS256 and EdDSA tokens
+// signed with a private key whose public key is in a JWKS file.
+type TokenVerifier struct {
+	secret    []byte
+	keys      *FileKeySet
+	issuers   []string
+	audiences []string
+}
+
+// apiTokens is the verifier configured by InitializeAPITokens, or nil when API tokens are disabled.
+var apiTokens *TokenVerifier
+
+// InitializeAPITokens sets up the verification of API tokens from the configuration.
+// API tokens are enabled by a signing key, a JWKS file, or both.
+func InitializeAPITokens() error {
+	if config.AppConfig.APISigningKey == "" && config.AppConfig.JWTKeysFile == "" {
+		return nil
+	}
+	var keys *FileKeySet
+	if config.AppConfig.JWTKeysFile != "" {
+		var err error
+		if keys, err = NewFileKeySet(config.AppConfig.JWTKeysFile); err != nil {
+			return err
+		}
+	}
+	apiTokens = NewTokenVerifier([]byte(config.AppConfig.APISigningKey), keys, config.AppConfig.JWTIssuers, config.AppConfig.JWTAudiences)
+	return nil
+}
+
+// APITokens returns the configured API token verifier, or nil when API tokens are disabled.
+func APITokens() *TokenVerifier {
+	return apiTokens
+}
+
+// NewTokenVerifier returns a verifier of tokens signed with the secret or with keys of the key set,
+// either of which may be empty. Empty issuer or audience lists accept any issuer or audience.
+func NewTokenVerifier(secret []byte, keys *FileKeySet, issuers, audiences []string) *TokenVerifier {
+	return &TokenVerifier{secret: secret, keys: keys, issuers: issuers, audiences: audiences}
+}
+
+// Verify checks an API token's signature, validity period, issuer and audience, and returns its claims.
+// Tokens must expire. Each algorithm only ever uses its own kind of key, so a public key from the JWKS file
+// cannot be abused as an HMAC secret.
+func (v *TokenVerifier) Verify(rawToken string) (*TokenClaims, error) {
+	claims := &TokenClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, v.key,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if len(v.issuers) > 0 && !slices.Contains(v.issuers, claims.Issuer) {
+		return nil, fmt.Errorf("%w: issuer %q is not accepted", ErrInvalidToken, claims.Issuer)
+	}
+	if len(v.audiences) > 0 && !slices.ContainsFunc(v.audiences, func(audience string) bool {
+		return slices.Contains(claims.Audience, audience)
+	}) {
+		return nil, fmt.Errorf("%w: audience %v is not accepted", ErrInvalidToken, claims.Audience)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// key returns the key verifying a token, according to its algorithm.
+func (v *TokenVerifier) key(token *jwt.Token) (any, error) {
+	if token.Method.Alg() == "HS256" {
+		if len(v.secret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return v.secret, nil
+	}
+	if v.keys == nil {
+		return nil, fmt.Errorf("%s tokens are not accepted", token.Method.Alg())
+	}
+	kid, _ := token.Header["kid"].(string)
+	return v.keys.Key(kid)
+}
+
+// TokenIssuer returns the issuer claimed by a token, without verifying it, to route it to the right verifier.
+func TokenIssuer(rawToken string) string {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(rawToken, &claims); err != nil {
+		return ""
+	}
+	return claims.Issuer
+}
+
+// FileKeySet is a key set read from a local JWKS file. The file is checked for changes every few seconds,
+// so keys are rotated by rewriting it: add the new key, move signers to it, then remove the old one.
+type FileKeySet struct {
+	path string
+
+	mu        sync.Mutex
+	keys      *KeySet
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+	now       func() time.Time // Replaceable in tests.
+}
+
+// NewFileKeySet reads a key set from a JWKS file.
+func NewFileKeySet(path string) (*FileKeySet, error) {
+	s := &FileKeySet{path: path, now: time.Now}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Key returns the key with the given ID, rereading the file first if it changed.
+// If the changed file cannot be read, the keys read last keep being used.
+func (s *FileKeySet) Key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := s.now(); now.Sub(s.checkedAt) >= keyFileCheckInterval {
+		s.checkedAt = now
+		if err := s.load(); err != nil {
+			slog.Warn("Failed to reload API token keys", slog.String("path", s.path), slog.Any("error", err))
+		}
+	}
+	return s.keys.Key(kid)
+}
+
+// load reads the file if it changed since it was last read. The caller holds the lock, if needed.
+func (s *FileKeySet) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if s.keys != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseKeySet(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.keys, s.modTime, s.size = keys, info.ModTime(), info.Size()
+	return nil
+}
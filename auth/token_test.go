@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyFile writes a JWKS file with the public keys of the given private keys, by key ID.
+func writeKeyFile(t *testing.T, path string, keys map[string]any) {
+	var jwks []map[string]string
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			jwks = append(jwks, map[string]string{
+				"kid": kid, "kty": "RSA",
+				"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		case ed25519.PrivateKey:
+			jwks = append(jwks, map[string]string{
+				"kid": kid, "kty": "OKP", "crv": "Ed25519",
+				"x": base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+			})
+		}
+	}
+	data, err := json.Marshal(map[string]any{"keys": jwks})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+// mint returns a token signed with the given method and key, with an hour of validity unless set otherwise.
+func mint(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	full := jwt.MapClaims{"sub": "billing-service", "iss": "billing", "aud": "shortener", "exp": time.Now().Add(time.Hour).Unix()}
+	for name, value := range claims {
+		full[name] = value
+	}
+	token := jwt.NewWithClaims(method, full)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestTokenVerifierHS256(t *testing.T) {
+	secret := []byte("a shared secret of enough length")
+	verifier := NewTokenVerifier(secret, nil, []string{"billing"}, []string{"shortener"})
+
+	claims, err := verifier.Verify(mint(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"scope": "links:write stats:read"}))
+	require.NoError(t, err)
+	assert.Equal(t, "billing-service", claims.Subject)
+	assert.True(t, claims.HasScope(ScopeLinksWrite))
+	assert.True(t, claims.HasScope(ScopeStatsRead))
+	assert.False(t, claims.HasScope(ScopeLinksRead))
+
+	for name, token := range map[string]string{
+		"wrong secret":   mint(t, jwt.SigningMethodHS256, []byte("another secret"), "", nil),
+		"wrong issuer":   mint(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"iss": "someone"}),
+		"wrong audience": mint(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"aud": "another-api"}),
+		"expired":        mint(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}),
+		"no expiry":      mint(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"exp": nil}),
+		"HS512":          mint(t, jwt.SigningMethodHS512, secret, "", nil),
+	} {
+		_, err := verifier.Verify(token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	// Without keys, only HS256 tokens are accepted.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = verifier.Verify(mint(t, jwt.SigningMethodRS256, key, "", nil))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestTokenVerifierKeyFile(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeKeyFile(t, path, map[string]any{"rsa-1": rsaKey, "ed-1": edKey})
+
+	keys, err := NewFileKeySet(path)
+	require.NoError(t, err)
+	verifier := NewTokenVerifier(nil, keys, nil, nil)
+
+	_, err = verifier.Verify(mint(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", nil))
+	assert.NoError(t, err)
+	_, err = verifier.Verify(mint(t, jwt.SigningMethodEdDSA, edKey, "ed-1", nil))
+	assert.NoError(t, err)
+
+	// A key cannot be used with another algorithm, and HS256 is refused without a secret.
+	_, err = verifier.Verify(mint(t, jwt.SigningMethodEdDSA, edKey, "rsa-1", nil))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = verifier.Verify(mint(t, jwt.SigningMethodHS256, []byte("guess"), "rsa-1", nil))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Keys are rotated by rewriting the file.
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writeKeyFile(t, path, map[string]any{"rsa-2": newKey})
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	keys.now = func() time.Time { return time.Now().Add(keyFileCheckInterval) }
+
+	_, err = verifier.Verify(mint(t, jwt.SigningMethodRS256, newKey, "rsa-2", nil))
+	assert.NoError(t, err)
+	_, err = verifier.Verify(mint(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", nil))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// A broken file keeps the previous keys.
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	keys.now = func() time.Time { return time.Now().Add(2 * keyFileCheckInterval) }
+	_, err = verifier.Verify(mint(t, jwt.SigningMethodRS256, newKey, "rsa-2", nil))
+	assert.NoError(t, err)
+}
+
+func TestTokenIssuer(t *testing.T) {
+	assert.Equal(t, "billing", TokenIssuer(mint(t, jwt.SigningMethodHS256, []byte("secret"), "", nil)))
+	assert.Empty(t, TokenIssuer("not a token"))
+}
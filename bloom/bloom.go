@@ -0,0 +1,83 @@
+// Package bloom implements the Bloom filter used to reject lookups of short codes that do not exist.
+package bloom
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+)
+
+// Filter is a Bloom filter of strings. It never reports an added item as missing,
+// and reports a missing item as present with a probability that grows as it fills up.
+// It is safe for concurrent use.
+type Filter struct {
+	mu      sync.RWMutex
+	words   []uint64 // The bit array.
+	m       uint64   // Number of bits.
+	k       uint64   // Number of hash functions.
+	setBits uint64   // Number of bits set, used to estimate the false-positive rate.
+
+	seeds [2]maphash.Seed // Seeds of the two hashes combined into the k bit positions.
+}
+
+// New creates a filter sized to hold capacity items with the given false-positive rate,
+// using the optimal number of bits and hash functions.
+func New(capacity int, fpRate float64) *Filter {
+	n := math.Max(float64(capacity), 1)
+	m := math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(math.Round(m/n*math.Ln2), 1)
+
+	words := (uint64(m) + 63) / 64
+	return &Filter{
+		words: make([]uint64, words),
+		m:     words * 64,
+		k:     uint64(k),
+		seeds: [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}
+}
+
+// Add adds an item to the filter.
+func (f *Filter) Add(item string) {
+	h1, h2 := f.hash(item)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f.words[word]&mask == 0 {
+			f.words[word] |= mask
+			f.setBits++
+		}
+	}
+}
+
+// Test reports whether an item may have been added to the filter.
+// A false result means the item was definitely never added.
+func (f *Filter) Test(item string) bool {
+	h1, h2 := f.hash(item)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.words[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// EstimatedFalsePositiveRate returns the probability that Test reports a missing item as present,
+// estimated from the fraction of bits currently set.
+func (f *Filter) EstimatedFalsePositiveRate() float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return math.Pow(float64(f.setBits)/float64(f.m), float64(f.k))
+}
+
+// hash returns the two 64-bit hashes of an item combined to derive its k bit positions.
+// The second hash is forced to be odd so the positions do not collapse onto a single bit.
+func (f *Filter) hash(item string) (uint64, uint64) {
+	return maphash.String(f.seeds[0], item), maphash.String(f.seeds[1], item) | 1
+}
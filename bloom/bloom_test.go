@@ -0,0 +1,36 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterHasNoFalseNegatives(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add("code-" + strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.Test("code-"+strconv.Itoa(i)))
+	}
+}
+
+func TestFilterFalsePositiveRate(t *testing.T) {
+	f := New(10000, 0.01)
+	assert.Zero(t, f.EstimatedFalsePositiveRate())
+	for i := 0; i < 10000; i++ {
+		f.Add("code-" + strconv.Itoa(i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.Test("missing-" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	// At capacity both the measured and the estimated rates stay close to the target.
+	assert.Less(t, float64(falsePositives)/10000, 0.02)
+	assert.InDelta(t, 0.01, f.EstimatedFalsePositiveRate(), 0.005)
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU[string, string](2)
+	c.Set("a", "1", time.Minute)
+	c.Set("b", "2", time.Minute)
+
+	// Reading "a" makes "b" the least recently used entry.
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	c.Set("c", "3", time.Minute)
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	value, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestLRUExpiresEntries(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewLRU[string, string](10)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", "1", time.Minute)
+	c.Set("b", "2", 10*time.Second)
+
+	now = now.Add(30 * time.Second)
+	_, ok := c.Get("b")
+	assert.False(t, ok)
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestLRURemoveAndPurge(t *testing.T) {
+	c := NewLRU[string, string](10)
+	c.Set("a", "1", time.Minute)
+	c.Set("b", "2", time.Minute)
+
+	c.Remove("a")
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+
+	// A non-positive TTL removes the key as well.
+	c.Set("b", "2", 0)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+
+	c.Set("c", "3", time.Minute)
+	c.Purge()
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestLRUDisabled(t *testing.T) {
+	c := NewLRU[string, string](0)
+	c.Set("a", "1", time.Minute)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+}
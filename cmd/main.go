@@ -1,23 +1,84 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/drunkleen/go-url-shortner/auth"
 	"github.com/drunkleen/go-url-shortner/config"
 	"github.com/drunkleen/go-url-shortner/handler"
+	"github.com/drunkleen/go-url-shortner/health"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/middleware"
 	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/drunkleen/go-url-shortner/tracing"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 // main initializes the URL Shortener API server. It loads the configuration,
 // sets up the Gin router with defined routes for creating and redirecting short URLs,
 // initializes the store service, and starts the server on the configured port.
+// On SIGINT or SIGTERM it shuts down gracefully: readiness starts failing, in-flight
+// requests are drained for the configured grace period, and the store is closed.
 func main() {
+	// "config print" shows the effective configuration instead of starting the server.
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "print" {
+		if err := config.Print(os.Stdout, os.Args[3:]); err != nil {
+			log.Fatalf("Invalid configuration:\n%v", err)
+		}
+		return
+	}
+
 	// Load the application configuration
 	config.LoadConfig()
 
-	// Initialize the Gin router
-	r := gin.Default()
+	// Failures are reported once run has returned, so its deferred cleanups, such as flushing traces, still run.
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the API until a termination signal, or until a server fails, then shuts down gracefully.
+func run() error {
+
+	// Reload the runtime settings on SIGHUP or when the config file changes.
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	go config.Watch(watchCtx)
+
+	// Set up tracing before any span can be started.
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter: config.AppConfig.TracingExporter,
+		Endpoint: config.AppConfig.TracingEndpoint,
+		Insecure: config.AppConfig.TracingInsecure,
+		File:     config.AppConfig.TracingFile,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("Failed to flush traces", slog.Any("error", err))
+		}
+	}()
+
+	// Initialize the Gin router with tracing, request IDs, structured access logs and panic recovery.
+	// The tracing middleware runs first so the request ID and access log share the request's span.
+	r := gin.New()
+	// Client IPs, which anonymous links are owned by, are only taken from the headers of trusted proxies.
+	if err := r.SetTrustedProxies(config.AppConfig.TrustedProxies); err != nil {
+		return fmt.Errorf("failed to set the trusted proxies: %w", err)
+	}
+	r.Use(otelgin.Middleware(tracing.ServiceName), middleware.RequestID(), middleware.Logger(), metrics.Middleware(), gin.Recovery())
 
 	// Define a GET route to serve a welcome message
 	// This route is used to test the API.
@@ -27,22 +88,186 @@ func main() {
 		})
 	})
 
-	// Define a POST route to create a short URL
-	r.POST("/create-short-url", func(c *gin.Context) {
+	// Define the liveness and readiness probes.
+	r.GET("/healthz", health.Liveness)
+	r.GET("/readyz", health.Readiness)
+
+	// Expose the Prometheus metrics, either on the main router or on a separate admin port.
+	// The route must be registered before "/:shortUrl" is matched against it.
+	// Servers that fail report it here, which shuts the service down.
+	serveErrs := make(chan error, 2)
+	var metricsServer *http.Server
+	if config.AppConfig.MetricsAddr() == "" {
+		r.GET("/metrics", metrics.Handler())
+	} else {
+		metricsServer = serveMetrics(config.AppConfig.MetricsAddr(), serveErrs)
+	}
+
+	// Define a POST route to create a short URL.
+	// Links created by a logged-in user are owned by its account, or by the workspace selected with X-Workspace-ID.
+	r.POST("/create-short-url", handler.Authenticate(), handler.RequireScope(auth.ScopeLinksWrite), handler.Authorize(store.PermissionCreateLinks), func(c *gin.Context) {
 		handler.CreateShortUrl(c)
 	})
 
+	// Define the link management API.
+	// Routes check the scope of API tokens, then the requester's permission on the links it manages.
+	// Only logged-in users and API tokens can change links; anonymous clients can only create and list them.
+	readLinks := []gin.HandlerFunc{handler.RequireScope(auth.ScopeLinksRead), handler.Authorize(store.PermissionViewLinks)}
+	writeLinks := []gin.HandlerFunc{handler.RequireScope(auth.ScopeLinksWrite), handler.Authorize(store.PermissionEditLinks)}
+	readStats := []gin.HandlerFunc{handler.RequireScope(auth.ScopeStatsRead), handler.Authorize(store.PermissionViewLinks)}
+	api := r.Group("/api/v1", handler.Authenticate())
+	api.POST("/auth/register", handler.Register)
+	api.POST("/auth/login", handler.Login)
+	api.POST("/auth/logout", handler.Logout)
+	api.GET("/auth/me", handler.Me)
+	api.POST("/auth/password", handler.ChangePassword)
+	api.POST("/auth/claims", handler.RequireScope(auth.ScopeLinksWrite), handler.ClaimLink)
+	api.GET("/auth/oidc/login", handler.OIDCLogin)
+	api.GET("/auth/oidc/callback", handler.OIDCCallback)
+	api.GET("/links", append(readLinks, handler.ListLinks)...)
+	api.POST("/links/bulk", append(writeLinks, handler.BulkUpdateLinks)...)
+	link := api.Group("/links/:code", handler.ValidateShortCode("code", "link not found"))
+	link.GET("/versions", append(readLinks, handler.LinkVersions)...)
+	link.POST("/rollback", append(writeLinks, handler.RollbackLink)...)
+	link.PATCH("", append(writeLinks, handler.UpdateLink)...)
+	link.DELETE("", append(writeLinks, handler.DeleteLink)...)
+	link.POST("/restore", append(writeLinks, handler.RestoreLink)...)
+	api.GET("/folders", append(readLinks, handler.ListFolders)...)
+	api.POST("/folders", append(writeLinks, handler.CreateFolder)...)
+	api.GET("/folders/:id", append(readLinks, handler.GetFolder)...)
+	api.PATCH("/folders/:id", append(writeLinks, handler.UpdateFolder)...)
+	api.DELETE("/folders/:id", append(writeLinks, handler.DeleteFolder)...)
+	api.GET("/stats", append(readStats, handler.LinkStats)...)
+	api.GET("/workspaces", handler.ListWorkspaces)
+	api.POST("/workspaces", handler.CreateWorkspace)
+	api.GET("/workspaces/:id", handler.GetWorkspace)
+	api.PATCH("/workspaces/:id", handler.UpdateWorkspace)
+	api.PUT("/workspaces/:id/members/:user", handler.SetMemberRole)
+	api.DELETE("/workspaces/:id/members/:user", handler.RemoveMember)
+	api.POST("/workspaces/:id/invitations", handler.CreateInvitation)
+	api.POST("/invitations/:token/accept", handler.AcceptInvitation)
+
+	// Define the admin API, authenticated with the admin token instead of user credentials.
+	admin := r.Group("/api/v1/admin", handler.RequireAdmin())
+	admin.GET("/audit", handler.AuditLog)
+	admin.GET("/audit/export", handler.ExportAuditLog)
+	admin.GET("/links", handler.SearchLinks)
+	adminLink := admin.Group("/links/:code", handler.ValidateShortCode("code", "link not found"))
+	adminLink.POST("/disable", handler.DisableLink)
+	adminLink.POST("/enable", handler.EnableLink)
+	admin.GET("/owners/:owner/ban", handler.GetBan)
+	admin.PUT("/owners/:owner/ban", handler.BanOwner)
+	admin.DELETE("/owners/:owner/ban", handler.UnbanOwner)
+	admin.GET("/reports", handler.ListReports)
+	admin.POST("/reports/:id/resolve", handler.ResolveReport)
+
 	// Define a GET route to handle short URL redirection
-	r.GET("/:shortUrl", func(c *gin.Context) {
+	// Short codes are checked before anything else, since they are looked up in the store as they are.
+	r.GET("/:shortUrl", handler.ValidateShortCode("shortUrl", "Url not found"), func(c *gin.Context) {
 		handler.HandleShortUrlRedirect(c)
 	})
 
-	// Initialize the store service for URL mapping
+	// Define the public route to report abusive links to the moderators.
+	r.POST("/:shortUrl/report", handler.ValidateShortCode("shortUrl", "Url not found"), handler.ReportLink)
+
+	// Set up the verification of API tokens, if a signing key or a JWKS file is configured.
+	if err := auth.InitializeAPITokens(); err != nil {
+		return fmt.Errorf("invalid API token keys: %w", err)
+	}
+
+	// Initialize the store service for URL mapping.
+	// The server starts serving right away and stays not ready until the store is connected.
 	store.InitializeStoreService()
 
-	// Start the server and listen on the configured port
-	log.Printf(">> Server is running on port %s", config.AppConfig.Port)
-	if err := r.Run(config.AppConfig.Port); err != nil {
-		log.Fatalf("Failed to run server: %v", err)
+	// Set up single sign-on, if an identity provider is configured. Its endpoints are discovered on first use.
+	auth.InitializeOIDC()
+
+	// Register the readiness checks reported by /readyz.
+	// Short codes are hashed from the URL and owner rather than taken from a key pool, so there is no pool to check.
+	health.Register("store", store.Ping)
+	health.Register("config", func(context.Context) error {
+		if !config.Loaded() {
+			return errors.New("configuration not loaded")
+		}
+		return nil
+	})
+
+	// Start the server and listen on the configured port.
+	server := &http.Server{
+		Addr:              config.AppConfig.ListenAddr(),
+		Handler:           r,
+		ReadTimeout:       config.AppConfig.ReadTimeout,
+		ReadHeaderTimeout: config.AppConfig.ReadTimeout,
+		WriteTimeout:      config.AppConfig.WriteTimeout,
+		IdleTimeout:       config.AppConfig.IdleTimeout,
+	}
+	go func() {
+		slog.Info("Server is running", slog.String("addr", config.AppConfig.ListenAddr()))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErrs <- fmt.Errorf("failed to run server: %w", err)
+		}
+	}()
+
+	// Wait for a termination signal, or for a server to fail.
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var serveErr error
+	select {
+	case <-signalCtx.Done():
+	case serveErr = <-serveErrs:
+		slog.Error("Server failed", slog.Any("error", serveErr))
+	}
+	stop()
+
+	shutdown(server, metricsServer)
+	return serveErr
+}
+
+// shutdown stops the service gracefully. It first makes readiness fail, and keeps serving for the drain delay
+// so load balancers notice and stop routing new traffic here. Then it drains in-flight requests for the
+// configured grace period, and finally closes the store.
+func shutdown(server, metricsServer *http.Server) {
+	slog.Info("Shutting down, draining in-flight requests", slog.Duration("drain_delay", config.AppConfig.ShutdownDrainDelay),
+		slog.Duration("grace_period", config.AppConfig.ShutdownGracePeriod))
+	health.SetShuttingDown()
+	time.Sleep(config.AppConfig.ShutdownDrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.AppConfig.ShutdownGracePeriod)
+	defer cancel()
+
+	// Stop accepting new connections and wait for in-flight requests to finish.
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to drain in-flight requests", slog.Any("error", err))
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down metrics server", slog.Any("error", err))
+		}
+	}
+
+	// Close the Redis client once no request can use it anymore.
+	if err := store.Close(); err != nil {
+		slog.Error("Failed to close store", slog.Any("error", err))
 	}
+	slog.Info("Server stopped")
+}
+
+// serveMetrics starts a separate admin HTTP server exposing the Prometheus metrics on the given address.
+// A failure of the server is sent to errs.
+func serveMetrics(addr string, errs chan<- error) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.HTTPHandler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: config.AppConfig.ReadTimeout,
+	}
+
+	go func() {
+		slog.Info("Metrics server is running", slog.String("addr", addr))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- fmt.Errorf("failed to run metrics server: %w", err)
+		}
+	}()
+	return server
 }
@@ -0,0 +1,461 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/logger"
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileEnv is the environment variable naming the config file when -config is not given.
+const configFileEnv = "CONFIG_FILE"
+
+// Suffixes of the variants that read a secret from a file, e.g. REDIS_PASSWORD_FILE, redis_password_file
+// or -redis-password-file, as used with Docker and Kubernetes secrets.
+const (
+	fileEnvSuffix  = "_FILE"
+	fileKeySuffix  = "_file"
+	fileFlagSuffix = "-file"
+)
+
+// field describes one configuration setting, as declared by the struct tags of Config.
+type field struct {
+	index    int    // The index of the field in Config.
+	key      string // The key in the config file.
+	env      string // The environment variable name.
+	flagName string // The command line flag name.
+	def      string // The default value.
+	unit     string // The unit of bare numbers for durations.
+	usage    string // The help text of the flag.
+	secret   bool   // Whether the value must be redacted when printed.
+	reload   bool   // Whether the value can change at runtime.
+}
+
+// Setting is the printable value of one configuration setting.
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// schema lists every configuration setting in declaration order.
+var schema = buildSchema()
+
+// buildSchema derives the settings from the struct tags of Config.
+func buildSchema() []field {
+	t := reflect.TypeOf(Config{})
+	fields := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag
+		key := tag.Get("key")
+		if key == "" {
+			continue
+		}
+		fields = append(fields, field{
+			index:    i,
+			key:      key,
+			env:      strings.ToUpper(key),
+			flagName: strings.ReplaceAll(key, "_", "-"),
+			def:      tag.Get("default"),
+			unit:     tag.Get("unit"),
+			usage:    tag.Get("usage"),
+			secret:   tag.Get("secret") == "true",
+			reload:   tag.Get("reload") == "true",
+		})
+	}
+	return fields
+}
+
+// flagValue records the raw value of a command line flag and whether it was given,
+// so flags only override other sources when they are actually set.
+type flagValue struct {
+	raw    string
+	set    bool
+	isBool bool
+}
+
+// String returns the raw value of the flag.
+func (v *flagValue) String() string { return v.raw }
+
+// Set records the raw value of the flag; it is parsed later together with the other sources.
+func (v *flagValue) Set(raw string) error {
+	v.raw, v.set = raw, true
+	return nil
+}
+
+// IsBoolFlag lets boolean flags be given without a value, e.g. -debug-mode.
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
+
+// loader reads the configuration from its sources. The flags are registered and parsed once,
+// while the file and environment are read again on every load.
+type loader struct {
+	configFile *string
+	flags      map[string]*flagValue
+	fileFlags  map[string]*flagValue // The -<name>-file flags of secret settings.
+	dotenv     map[string]string     // Variables of the .env file, as read by the last load.
+}
+
+// newLoader registers a flag for every setting, plus -config, on the given flag set.
+// Secret settings also get a -<name>-file flag reading the value from a file.
+func newLoader(fs *flag.FlagSet) *loader {
+	l := &loader{flags: make(map[string]*flagValue, len(schema)), fileFlags: map[string]*flagValue{}}
+	l.configFile = fs.String("config", "", "Path of a YAML or TOML config file (can also be set as "+configFileEnv+").")
+
+	zero := reflect.ValueOf(Config{})
+	for _, f := range schema {
+		value := &flagValue{raw: f.def, isBool: zero.Field(f.index).Kind() == reflect.Bool}
+		l.flags[f.key] = value
+		fs.Var(value, f.flagName, f.usage+" (can also be set as "+f.env+").")
+
+		if f.secret {
+			fileValue := &flagValue{}
+			l.fileFlags[f.key] = fileValue
+			fs.Var(fileValue, f.flagName+fileFlagSuffix, "File containing the "+f.flagName+" secret (can also be set as "+f.env+fileEnvSuffix+").")
+		}
+	}
+	return l
+}
+
+// Load parses the command line arguments with the given flag set and loads the configuration
+// from flags, environment variables, the config file and defaults, in that order of priority.
+// It returns every parsing and validation error at once.
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	l := newLoader(fs)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	return l.load()
+}
+
+// load builds the configuration from defaults, the config file, the environment and the flags,
+// then validates it.
+func (l *loader) load() (Config, error) {
+	var cfg Config
+	var errs []error
+	v := reflect.ValueOf(&cfg).Elem()
+
+	// Defaults come first so every other source can override them.
+	for _, f := range schema {
+		if f.def == "" {
+			continue
+		}
+		if err := setField(v.Field(f.index), f, f.def); err != nil {
+			errs = append(errs, fmt.Errorf("default %s: %w", f.key, err))
+		}
+	}
+
+	// Read environment variables from a .env file if it exists. The file is read again on every load,
+	// so reloads pick up its changes; variables set in the process environment take precedence over it.
+	dotenv, err := godotenv.Read()
+	if err != nil {
+		slog.Debug("No .env file found, using the environment, config file or defaults.")
+	}
+	l.dotenv = dotenv
+
+	// Then the config file, if one is given.
+	if path := l.configPath(); path != "" {
+		errs = append(errs, loadFile(v, path)...)
+	}
+
+	// Then the environment.
+	for _, f := range schema {
+		var path string
+		if f.secret {
+			path = l.getenv(f.env + fileEnvSuffix)
+		}
+		raw, ok, err := valueOrFile(l.getenv(f.env), path)
+		if err == nil && ok {
+			err = setField(v.Field(f.index), f, raw)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("env %s: %w", f.env, err))
+		}
+	}
+
+	// And finally the flags that were given on the command line.
+	// Empty flags count as not given, so wrappers such as the Makefile can pass unset variables.
+	for _, f := range schema {
+		var raw, path string
+		if value := l.flags[f.key]; value.set {
+			raw = value.raw
+		}
+		if fileValue := l.fileFlags[f.key]; fileValue != nil {
+			path = fileValue.raw
+		}
+		raw, ok, err := valueOrFile(raw, path)
+		if err == nil && ok {
+			err = setField(v.Field(f.index), f, raw)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("flag -%s: %w", f.flagName, err))
+		}
+	}
+
+	cfg.normalize()
+	errs = append(errs, cfg.validate()...)
+	return cfg, errors.Join(errs...)
+}
+
+// configPath returns the config file given by -config or CONFIG_FILE, or an empty string if there is none.
+func (l *loader) configPath() string {
+	if *l.configFile != "" {
+		return *l.configFile
+	}
+	return l.getenv(configFileEnv)
+}
+
+// getenv returns the value of an environment variable, from the process environment or else the .env file.
+func (l *loader) getenv(name string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return l.dotenv[name]
+}
+
+// loadFile reads a YAML or TOML config file into v, chosen by the file extension.
+// Unknown keys are reported as errors so typos do not go unnoticed.
+func loadFile(v reflect.Value, path string) []error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{fmt.Errorf("config file: %w", err)}
+	}
+
+	var errs []error
+	values := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return []error{fmt.Errorf("config file %s: unsupported extension, expected .yaml, .yml or .toml", path)}
+	}
+	if err != nil {
+		return []error{fmt.Errorf("config file %s: %w", path, err)}
+	}
+
+	fieldsByKey := make(map[string]field, len(schema))
+	for _, f := range schema {
+		fieldsByKey[f.key] = f
+	}
+
+	// Secrets can be given through a <key>_file entry naming the file that holds them.
+	for _, f := range schema {
+		secretPath, ok := values[f.key+fileKeySuffix]
+		if !f.secret || !ok {
+			continue
+		}
+		delete(values, f.key+fileKeySuffix)
+		if _, ok := values[f.key]; ok {
+			errs = append(errs, fmt.Errorf("config file %s: set either %s or %s%s, not both", path, f.key, f.key, fileKeySuffix))
+			continue
+		}
+		secret, err := readSecretFile(fmt.Sprint(secretPath))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: %s%s: %w", path, f.key, fileKeySuffix, err))
+			continue
+		}
+		values[f.key] = secret
+	}
+
+	// Visit the keys in a stable order so errors are reported deterministically.
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		f, ok := fieldsByKey[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("config file %s: unknown key %q", path, key))
+			continue
+		}
+		if err := setFieldFromFile(v.Field(f.index), f, value); err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: %s: %w", path, key, err))
+		}
+	}
+	return errs
+}
+
+// valueOrFile returns a setting given either directly or through the path of a file holding it.
+// It reports whether the setting was given at all, and fails if both forms are used.
+func valueOrFile(value, path string) (string, bool, error) {
+	switch {
+	case path != "" && value != "":
+		return "", false, errors.New("set either the value or its file, not both")
+	case path != "":
+		secret, err := readSecretFile(path)
+		return secret, err == nil, err
+	default:
+		return value, value != "", nil
+	}
+}
+
+// readSecretFile reads a secret from a file, without the trailing newline most editors add.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// setFieldFromFile sets a field from a value decoded from a config file.
+// Lists may be written either as native lists or as comma-separated strings.
+func setFieldFromFile(target reflect.Value, f field, value any) error {
+	if list, ok := value.([]any); ok {
+		if target.Kind() != reflect.Slice {
+			return fmt.Errorf("expected a single value, got a list")
+		}
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		target.Set(reflect.ValueOf(items))
+		return nil
+	}
+	return setField(target, f, fmt.Sprint(value))
+}
+
+// setField parses a raw string into the field according to its type.
+func setField(target reflect.Value, f field, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	// Durations are checked before ints, since time.Duration is an int64.
+	if target.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := parseDuration(raw, f.unit)
+		if err != nil {
+			return err
+		}
+		target.SetInt(int64(duration))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		target.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		target.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		target.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		target.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", target.Type())
+	}
+	return nil
+}
+
+// parseDuration parses a Go duration such as "90s" or "1h30m".
+// When unit is set, a bare number is read in that unit, so "60" with unit "m" is one hour.
+func parseDuration(raw, unit string) (time.Duration, error) {
+	if unit != "" {
+		if n, err := strconv.Atoi(raw); err == nil {
+			base, err := time.ParseDuration("1" + unit)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * base, nil
+		}
+	}
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration", raw)
+	}
+	return duration, nil
+}
+
+// formatField renders a field value for printing, redacting secrets and URL credentials.
+func formatField(value reflect.Value, f field) string {
+	var s string
+	switch v := value.Interface().(type) {
+	case time.Duration:
+		s = v.String()
+	case []string:
+		s = strings.Join(v, ",")
+	default:
+		s = fmt.Sprint(v)
+	}
+	if f.secret && s != "" {
+		return logger.Redacted
+	}
+	return logger.RedactURL(s)
+}
+
+// Settings returns every setting of cfg in schema order, with secrets redacted.
+func Settings(cfg Config) []Setting {
+	v := reflect.ValueOf(cfg)
+	settings := make([]Setting, len(schema))
+	for i, f := range schema {
+		settings[i] = Setting{Key: f.key, Value: formatField(v.Field(f.index), f)}
+	}
+	return settings
+}
+
+// Print loads the configuration from the given command line arguments, the environment and
+// the config file, and writes the effective configuration to w as YAML with secrets redacted.
+// The configuration is written even if it is invalid; the validation errors are returned.
+func Print(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	l := newLoader(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, loadErr := l.load()
+
+	v := reflect.ValueOf(cfg)
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range schema {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Value: formatField(v.Field(f.index), f)}
+		if kind := v.Field(f.index).Kind(); kind == reflect.String || f.secret {
+			value.Tag = "!!str"
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: f.key, LineComment: f.usage}
+		doc.Content = append(doc.Content, key, value)
+	}
+
+	fmt.Fprintln(w, "# Effective configuration, secrets redacted.")
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return loadErr
+}
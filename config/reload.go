@@ -0,0 +1,134 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/logger"
+)
+
+var (
+	// runtimeConfig holds the current configuration; it is swapped atomically on reload.
+	runtimeConfig atomic.Pointer[Config]
+
+	// activeLoader is the loader LoadConfig used, so reloads read the same flags and config file.
+	activeLoader *loader
+
+	// reloadMu serializes reloads triggered by SIGHUP and by the file watcher.
+	reloadMu sync.Mutex
+)
+
+// Reload reads the config file and environment again and atomically swaps in the new values of
+// reloadable settings. Settings that cannot change at runtime, such as the listen port or the
+// store backend, keep their current values, and a warning is logged for each one that changed.
+// If the new configuration is invalid, nothing changes and the errors are returned.
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if activeLoader == nil {
+		return errors.New("configuration not loaded")
+	}
+	next, err := activeLoader.load()
+	if err != nil {
+		return err
+	}
+
+	current := Runtime()
+	currentValue := reflect.ValueOf(current).Elem()
+	nextValue := reflect.ValueOf(&next).Elem()
+	for _, f := range schema {
+		currentField, nextField := currentValue.Field(f.index), nextValue.Field(f.index)
+		if reflect.DeepEqual(currentField.Interface(), nextField.Interface()) {
+			continue
+		}
+		if !f.reload {
+			slog.Warn("Setting cannot be changed without a restart, keeping the current value",
+				slog.String("setting", f.key),
+				slog.String("current", formatField(currentField, f)),
+				slog.String("requested", formatField(nextField, f)),
+			)
+			nextField.Set(currentField)
+			continue
+		}
+		slog.Info("Setting reloaded",
+			slog.String("setting", f.key),
+			slog.String("previous", formatField(currentField, f)),
+			slog.String("value", formatField(nextField, f)),
+		)
+	}
+
+	// Apply the reloadable settings that are not read through Runtime().
+	if err := logger.SetLevel(next.LogLevel); err != nil {
+		return err
+	}
+	runtimeConfig.Store(&next)
+	return nil
+}
+
+// Watch reloads the configuration on SIGHUP and whenever the config file changes, until ctx is done.
+// The file is checked every config_watch_interval; an interval of 0 only reloads on SIGHUP.
+func Watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	// Poll the config file's modification time; this also catches files replaced through
+	// symlink swaps, as done for mounted Kubernetes ConfigMaps.
+	var tick <-chan time.Time
+	path := configFilePath()
+	if path != "" && AppConfig.ConfigWatchInterval > 0 {
+		ticker := time.NewTicker(AppConfig.ConfigWatchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	lastModified := modTime(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			slog.Info("Received SIGHUP, reloading configuration")
+		case <-tick:
+			modified := modTime(path)
+			if modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+			slog.Info("Config file changed, reloading configuration", slog.String("path", path))
+		}
+
+		if err := Reload(); err != nil {
+			slog.Error("Failed to reload configuration, keeping the current one", slog.Any("error", err))
+		}
+	}
+}
+
+// configFilePath returns the config file used at startup, or an empty string if there is none.
+func configFilePath() string {
+	if activeLoader == nil {
+		return ""
+	}
+	return activeLoader.configPath()
+}
+
+// modTime returns the modification time of a file, or the zero time if it cannot be read.
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadSwapsOnlyReloadableSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("port: 9000\nlog_level: info\ndefault_redirect_code: 308\n"), 0o600))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l := newLoader(fs)
+	assert.NoError(t, fs.Parse([]string{"-config", file}))
+	cfg, err := l.load()
+	assert.NoError(t, err)
+	activeLoader = l
+	runtimeConfig.Store(&cfg)
+	t.Cleanup(func() {
+		activeLoader = nil
+		runtimeConfig.Store(nil)
+	})
+
+	// Change a reloadable and a non-reloadable setting.
+	assert.NoError(t, os.WriteFile(file, []byte("port: 9001\nlog_level: debug\ndefault_redirect_code: 302\n"), 0o600))
+	assert.NoError(t, Reload())
+
+	assert.Equal(t, "debug", Runtime().LogLevel)
+	assert.Equal(t, 302, Runtime().DefaultRedirectCode)
+	assert.Equal(t, 9000, Runtime().Port)
+
+	// An invalid file is rejected and the current configuration is kept.
+	assert.NoError(t, os.WriteFile(file, []byte("default_redirect_code: 200\n"), 0o600))
+	assert.Error(t, Reload())
+	assert.Equal(t, 302, Runtime().DefaultRedirectCode)
+}
+
+func TestReloadReadsDotenvAgain(t *testing.T) {
+	dir, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(dir) })
+	assert.NoError(t, os.WriteFile(".env", []byte("LOG_LEVEL=info\n"), 0o600))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l := newLoader(fs)
+	assert.NoError(t, fs.Parse(nil))
+	cfg, err := l.load()
+	assert.NoError(t, err)
+	activeLoader = l
+	runtimeConfig.Store(&cfg)
+	t.Cleanup(func() {
+		activeLoader = nil
+		runtimeConfig.Store(nil)
+	})
+
+	// The .env file is read again, and the process environment still takes precedence over it.
+	assert.NoError(t, os.WriteFile(".env", []byte("LOG_LEVEL=debug\nDEFAULT_REDIRECT_CODE=302\n"), 0o600))
+	t.Setenv("DEFAULT_REDIRECT_CODE", "307")
+	assert.NoError(t, Reload())
+	assert.Equal(t, "debug", Runtime().LogLevel)
+	assert.Equal(t, 307, Runtime().DefaultRedirectCode)
+	_, set := os.LookupEnv("LOG_LEVEL")
+	assert.False(t, set)
+}
@@ -0,0 +1,324 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/auth"
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+)
+
+// sessionCookieName is the name of the cookie holding the session token of a logged-in user.
+const sessionCookieName = "session"
+
+// Keys under which the Authenticate middleware stores the logged-in user and its session token in the Gin context.
+const (
+	userContextKey    = "user"
+	sessionContextKey = "session_token"
+)
+
+// AccountRequest is the body of registration and login requests.
+type AccountRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// PasswordChangeRequest is the body of password change requests.
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// ClaimRequest is the body of link claim requests.
+type ClaimRequest struct {
+	ClaimToken string `json:"claim_token" binding:"required"`
+}
+
+// accountView is the representation of a user returned by the API, without its password hash.
+type accountView struct {
+	ID          string    `json:"id"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at,omitempty"`
+}
+
+// Authenticate is a Gin middleware that identifies the logged-in user of a request, from a bearer token
+// in the Authorization header or else from the session cookie, and attaches the requester to the request
+// context as the actor of the link mutations it makes.
+// Requests with a refused bearer token get a 401. Requests without a valid session carry on anonymously,
+// and a stale cookie is cleared; requests whose session cannot be checked because the store is unavailable get a 503.
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticate(c) {
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(store.WithActor(c.Request.Context(), requestActor(c)))
+		c.Next()
+	}
+}
+
+// authenticate implements Authenticate. It returns false after responding if the request is refused.
+func authenticate(c *gin.Context) bool {
+	if rawToken, ok := bearerToken(c); ok {
+		return authenticateBearer(c, rawToken)
+	}
+
+	token, err := c.Cookie(sessionCookieName)
+	if err != nil || token == "" {
+		return true
+	}
+
+	session, err := store.GetSession(c.Request.Context(), token)
+	var user *store.User
+	if err == nil {
+		user, err = store.GetUser(c.Request.Context(), session.UserID)
+	}
+	switch {
+	case errors.Is(err, store.ErrSessionNotFound), errors.Is(err, store.ErrUserNotFound):
+		clearSessionCookie(c)
+	case err != nil:
+		slog.WarnContext(c.Request.Context(), "Failed to load session", slog.Any("error", err))
+		serviceUnavailable(c)
+		return false
+	default:
+		c.Set(userContextKey, user)
+		c.Set(sessionContextKey, token)
+	}
+	return true
+}
+
+// Register is a Gin handler function that creates a user account and logs it in.
+func Register(c *gin.Context) {
+	var request AccountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkPasswordLength(c, request.Password) {
+		return
+	}
+
+	hash, err := auth.HashPassword(request.Password)
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+	user := &store.User{Email: request.Email, PasswordHash: hash}
+	if err := store.CreateUser(c.Request.Context(), user); err != nil {
+		if errors.Is(err, store.ErrEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+			return
+		}
+		accountError(c, err)
+		return
+	}
+
+	user, err = logIn(c, user)
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"user": newAccountView(user)})
+}
+
+// Login is a Gin handler function that checks a user's email address and password and starts a session.
+// Unknown email addresses and wrong passwords get the same response, so they cannot be told apart.
+func Login(c *gin.Context) {
+	var request AccountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := store.GetUserByEmail(c.Request.Context(), request.Email)
+	hash := ""
+	switch {
+	case err == nil:
+		hash = user.PasswordHash
+	case !errors.Is(err, store.ErrUserNotFound):
+		accountError(c, err)
+		return
+	}
+	if err := auth.CheckPassword(hash, request.Password); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+
+	user, err = logIn(c, user)
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": newAccountView(user)})
+}
+
+// Logout is a Gin handler function that ends the current session.
+func Logout(c *gin.Context) {
+	if token := c.GetString(sessionContextKey); token != "" {
+		if err := store.DeleteSession(c.Request.Context(), token); err != nil {
+			accountError(c, err)
+			return
+		}
+	}
+	clearSessionCookie(c)
+	c.Status(http.StatusNoContent)
+}
+
+// Me is a Gin handler function that returns the logged-in user.
+func Me(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": newAccountView(user)})
+}
+
+// ChangePassword is a Gin handler function that changes the logged-in user's password.
+// The current password is required, and every other session of the user is ended.
+func ChangePassword(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return
+	}
+	var request PasswordChangeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := auth.CheckPassword(user.PasswordHash, request.CurrentPassword); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if !checkPasswordLength(c, request.NewPassword) {
+		return
+	}
+
+	hash, err := auth.HashPassword(request.NewPassword)
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+	if _, err := store.UpdateUser(c.Request.Context(), user.ID, func(u *store.User) { u.PasswordHash = hash }); err != nil {
+		accountError(c, err)
+		return
+	}
+	if err := store.DeleteUserSessions(c.Request.Context(), user.ID, c.GetString(sessionContextKey)); err != nil {
+		accountError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ClaimLink is a Gin handler function that moves a link created anonymously to the logged-in user or the subject
+// of the API token, given the claim token returned when the link was created. Unknown, expired or used tokens get a 404.
+func ClaimLink(c *gin.Context) {
+	if currentUser(c) == nil && apiToken(c) == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return
+	}
+	var request ClaimRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	link, err := store.ClaimLink(c.Request.Context(), request.ClaimToken, personalOwner(c))
+	if errors.Is(err, store.ErrClaimNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Claim not found"})
+		return
+	}
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"link": link})
+}
+
+// checkPasswordLength responds 400 and returns false for new passwords that are too short or too long.
+// Lengths are counted in bytes, as bcrypt does, not in characters.
+func checkPasswordLength(c *gin.Context, password string) bool {
+	if len(password) < auth.MinPasswordLength || len(password) > auth.MaxPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("password must be %d to %d bytes long", auth.MinPasswordLength, auth.MaxPasswordLength)})
+		return false
+	}
+	return true
+}
+
+// logIn records a user's login and starts a session whose cookie is set on the response.
+// It returns the updated user.
+func logIn(c *gin.Context, user *store.User) (*store.User, error) {
+	user, err := store.UpdateUser(c.Request.Context(), user.ID, func(u *store.User) {
+		u.LastLoginAt = time.Now().UTC()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := config.AppConfig.SessionTTL
+	token, _, err := store.CreateSession(c.Request.Context(), user.ID, ttl)
+	if err != nil {
+		return nil, err
+	}
+	setSessionCookie(c, token, int(ttl.Seconds()))
+	return user, nil
+}
+
+// bearerToken returns the token of an Authorization header using the Bearer scheme.
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// currentUser returns the user logged in by the Authenticate middleware, or nil for anonymous requests.
+func currentUser(c *gin.Context) *store.User {
+	value, _ := c.Get(userContextKey)
+	user, _ := value.(*store.User)
+	return user
+}
+
+// setSessionCookie sets the session cookie.
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	setCookie(c, sessionCookieName, token, maxAge)
+}
+
+// setCookie sets a cookie of the authentication flows. It is HTTP-only and not sent along cross-site
+// requests other than top-level navigations, and is restricted to HTTPS when short links are served over HTTPS.
+// A negative maxAge removes the cookie.
+func setCookie(c *gin.Context, name, value string, maxAge int) {
+	secure := strings.HasPrefix(config.AppConfig.PublicBaseURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, "/", "", secure, true)
+}
+
+// clearSessionCookie removes the session cookie from the client.
+func clearSessionCookie(c *gin.Context) {
+	setSessionCookie(c, "", -1)
+}
+
+// newAccountView returns the API representation of a user.
+func newAccountView(user *store.User) accountView {
+	return accountView{ID: user.ID, Email: user.Email, CreatedAt: user.CreatedAt, LastLoginAt: user.LastLoginAt}
+}
+
+// accountError responds to a failed account operation.
+func accountError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrUnavailable) {
+		slog.WarnContext(c.Request.Context(), "Account operation failed", slog.Any("error", err))
+		serviceUnavailable(c)
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), "Account operation failed", slog.Any("error", err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Account operation failed"})
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterPasswordLength(t *testing.T) {
+	r := gin.New()
+	r.POST("/register", Register)
+	register := func(password string) int {
+		body := fmt.Sprintf(`{"email": "length-%d@example.com", "password": %q}`, time.Now().UnixNano(), password)
+		req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// bcrypt limits passwords to 72 bytes, whatever the number of characters.
+	assert.Equal(t, http.StatusCreated, register(strings.Repeat("é", 36)))
+	assert.Equal(t, http.StatusBadRequest, register(strings.Repeat("é", 37)))
+	assert.Equal(t, http.StatusBadRequest, register("short"))
+}
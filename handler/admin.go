@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin is a Gin middleware that restricts the admin API to requests carrying the admin_token
+// as a bearer token. The admin API is disabled, and answers 404, while no admin token is configured.
+// Link mutations made through it are attributed to the admin.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminToken := config.AppConfig.AdminToken
+		if adminToken == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The admin API is disabled"})
+			return
+		}
+		token, ok := bearerToken(c)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Admin token required"})
+			return
+		}
+		actor := store.Actor{Type: store.ActorAdmin, ClientIP: c.ClientIP()}
+		c.Request = c.Request.WithContext(store.WithActor(c.Request.Context(), actor))
+		c.Next()
+	}
+}
+
+// AuditLog is a Gin handler function that lists the audit events of link mutations, newest first,
+// one page at a time. The response holds the page of events and the cursor of the next page, if any.
+//
+// Query parameters:
+//
+//	code - the short code of the mutated link
+//	actor - the ID of the user, token subject or anonymous client who made the mutations
+//	action - create, update, delete, restore, purge, transfer, disable or enable
+//	since, until - RFC 3339 bounds of the time of the events
+//	limit - page size, up to 100 (default 20)
+//	cursor - the next_cursor of the previous page
+func AuditLog(c *gin.Context) {
+	query, err := auditQuery(c)
+	if err == nil && c.Query("limit") != "" {
+		query.Limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || query.Limit < 1 || query.Limit > store.MaxListLimit {
+			err = errors.New("limit must be between 1 and 100")
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := store.ListAuditEvents(c.Request.Context(), query)
+	if err != nil {
+		auditError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ExportAuditLog is a Gin handler function that exports the audit events of link mutations as
+// newline-delimited JSON, oldest first. It takes the filters of AuditLog, without paging; a cursor
+// resumes an interrupted export after the last event received.
+func ExportAuditLog(c *gin.Context) {
+	query, err := auditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The response starts with the first event, so errors found before it can still be reported as such.
+	started := false
+	encoder := json.NewEncoder(c.Writer)
+	err = store.ExportAuditEvents(c.Request.Context(), query, func(event *store.AuditEvent) error {
+		if !started {
+			started = true
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Header("Content-Disposition", `attachment; filename="audit.ndjson"`)
+			c.Status(http.StatusOK)
+		}
+		return encoder.Encode(event)
+	})
+	switch {
+	case err != nil && !started:
+		auditError(c, err)
+	case err != nil:
+		// Too late to report it; the client gets a truncated export it can resume from its last event.
+		slog.WarnContext(c.Request.Context(), "Audit log export interrupted", slog.Any("error", err))
+	case !started:
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+	}
+}
+
+// auditQuery builds the store query of an audit log listing or export from the request's query parameters.
+func auditQuery(c *gin.Context) (store.AuditQuery, error) {
+	query := store.AuditQuery{
+		Code:   c.Query("code"),
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+		Cursor: c.Query("cursor"),
+	}
+	switch query.Action {
+	case "", store.AuditActionCreate, store.AuditActionUpdate, store.AuditActionDelete,
+		store.AuditActionRestore, store.AuditActionPurge, store.AuditActionTransfer,
+		store.AuditActionDisable, store.AuditActionEnable:
+	default:
+		return query, errors.New("action must be create, update, delete, restore, purge, transfer, disable or enable")
+	}
+
+	var err error
+	if query.Since, err = queryTime(c, "since"); err != nil {
+		return query, err
+	}
+	if query.Until, err = queryTime(c, "until"); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+// auditError responds to a failure to read the audit log.
+func auditError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), "Failed to read the audit log", slog.Any("error", err))
+		serviceUnavailable(c)
+	default:
+		slog.ErrorContext(c.Request.Context(), "Failed to read the audit log", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read the audit log"})
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+)
+
+// FolderRequest is the body of folder creation and update requests.
+// Fields left out of an update keep their current value.
+type FolderRequest struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Description *string `json:"description" binding:"omitempty,max=1000"`
+}
+
+// CreateFolder is a Gin handler function that creates a folder for the requesting owner.
+func CreateFolder(c *gin.Context) {
+	var request FolderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	folder := &store.Folder{Owner: requestOwner(c), Name: *request.Name}
+	if request.Description != nil {
+		folder.Description = *request.Description
+	}
+	if err := store.CreateFolder(c.Request.Context(), folder); err != nil {
+		folderError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, folder)
+}
+
+// ListFolders is a Gin handler function that lists the folders of the requesting owner, sorted by name.
+func ListFolders(c *gin.Context) {
+	folders, err := store.ListFolders(c.Request.Context(), requestOwner(c))
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"folders": folders})
+}
+
+// GetFolder is a Gin handler function that returns one of the requesting owner's folders.
+func GetFolder(c *gin.Context) {
+	folder, err := store.GetFolder(c.Request.Context(), requestOwner(c), c.Param("id"))
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, folder)
+}
+
+// UpdateFolder is a Gin handler function that renames or describes one of the requesting owner's folders.
+func UpdateFolder(c *gin.Context) {
+	var request FolderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folder, err := store.UpdateFolder(c.Request.Context(), requestOwner(c), c.Param("id"), func(f *store.Folder) {
+		if request.Name != nil {
+			f.Name = *request.Name
+		}
+		if request.Description != nil {
+			f.Description = *request.Description
+		}
+	})
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, folder)
+}
+
+// DeleteFolder is a Gin handler function that deletes one of the requesting owner's folders.
+// The links in the folder are kept, outside of any folder.
+func DeleteFolder(c *gin.Context) {
+	if err := store.DeleteFolder(c.Request.Context(), requestOwner(c), c.Param("id")); err != nil {
+		folderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// folderError responds to a failed folder operation.
+func folderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrFolderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), "Folder operation failed", slog.Any("error", err))
+		serviceUnavailable(c)
+	default:
+		slog.ErrorContext(c.Request.Context(), "Folder operation failed", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Folder operation failed"})
+	}
+}
@@ -1,21 +1,34 @@
 package handler
 
 import (
-	"log"
-	"net"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
 	"github.com/drunkleen/go-url-shortner/shortener"
 	"github.com/drunkleen/go-url-shortner/store"
 	"github.com/drunkleen/go-url-shortner/utils"
 	"github.com/gin-gonic/gin"
 )
 
+// retryAfterSeconds is the delay suggested to clients through the Retry-After header when the store is unavailable.
+const retryAfterSeconds = 5
+
+// UrlCreationRequest is the body of a short URL creation request.
+// Only the URL is required; the other fields are optional link metadata.
 type UrlCreationRequest struct {
-	LongUrl string `json:"url" binding:"required"`
-	UserId  string
+	LongUrl      string     `json:"url" binding:"required"`
+	Title        string     `json:"title"`
+	Tags         []string   `json:"tags"`
+	Folder       string     `json:"folder"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	RedirectCode int        `json:"redirect_code" binding:"omitempty,oneof=301 302 307 308"`
+	UserId       string
 }
 
 // CreateShortUrl is a Gin handler function that creates a short URL given a long URL and saves it into the store.
@@ -29,41 +42,153 @@ func CreateShortUrl(c *gin.Context) {
 		return
 	}
 
-	// Generate a UUID from the client IP address.
-	creationRequest.UserId = utils.GenerateUUIDFromIP(getClientIP(c))
+	// The link is owned by the requester.
+	creationRequest.UserId = requestOwner(c)
+
+	// Banned owners cannot create links, whether in their own name or in a workspace's.
+	banned, err := store.IsBanned(c.Request.Context(), creationRequest.UserId, personalOwner(c))
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed to check owner ban", slog.Any("error", err))
+		serviceUnavailable(c)
+		return
+	}
+	if banned {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account is banned from creating links"})
+		return
+	}
 
 	// Generate a short URL given the long URL and the generated UUID.
 	shortUrl := shortener.GenerateShortLink(creationRequest.LongUrl, creationRequest.UserId)
 
-	// Save the short URL mapping into the store.
-	if err := store.SaveUrlMapping(shortUrl, creationRequest.LongUrl, creationRequest.UserId); err != nil {
+	// The folder, if any, must be one of the owner's.
+	if creationRequest.Folder != "" {
+		if _, err := store.GetFolder(c.Request.Context(), creationRequest.UserId, creationRequest.Folder); err != nil {
+			if errors.Is(err, store.ErrFolderNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "folder not found"})
+				return
+			}
+			folderError(c, err)
+			return
+		}
+	}
+
+	// Save the link and its metadata into the store.
+	link := &store.Link{
+		Code:         shortUrl,
+		Destination:  creationRequest.LongUrl,
+		Owner:        creationRequest.UserId,
+		Title:        creationRequest.Title,
+		Tags:         creationRequest.Tags,
+		Folder:       creationRequest.Folder,
+		ExpiresAt:    creationRequest.ExpiresAt,
+		RedirectCode: creationRequest.RedirectCode,
+	}
+	if user := currentUser(c); user != nil {
+		link.CreatedBy = user.ID
+	}
+	if err := store.SaveLink(c.Request.Context(), link); err != nil {
+		if errors.Is(err, store.ErrCodeTaken) {
+			existingShortUrl(c, link)
+			return
+		}
+		if errors.Is(err, store.ErrCodeUnavailable) {
+			c.JSON(http.StatusConflict, gin.H{"error": "This short url is unavailable"})
+			return
+		}
 		// If an error occurs while saving the mapping, log the error and return an Internal Server Error response.
-		log.Printf("Failed to save url mapping: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Failed to save url mapping", slog.String("short_url", shortUrl), slog.Any("error", err))
+		if errors.Is(err, store.ErrUnavailable) {
+			serviceUnavailable(c)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save url mapping"})
 		return
 	}
 
-	// Return the created short URL as a JSON response.
-	c.JSON(http.StatusCreated, gin.H{
+	metrics.RecordLinkCreated()
+
+	// Return the created short URL and link as a JSON response.
+	response := gin.H{
 		"message":   "short url created successfully",
-		"short_url": config.AppConfig.Host + config.AppConfig.Port + "/" + shortUrl,
-	})
+		"short_url": config.ShortURL(shortUrl),
+		"link":      link,
+	}
+	// Anonymous clients get a token to claim the link once they have an account. It is only given on creation,
+	// so other clients behind the same IP address cannot get one by creating the same URL again.
+	if currentUser(c) == nil && apiToken(c) == nil {
+		token, err := store.CreateClaim(c.Request.Context(), link, config.AppConfig.ClaimTTL)
+		if err != nil {
+			// Not worth failing the creation for; the link just cannot be claimed.
+			slog.WarnContext(c.Request.Context(), "Failed to create claim", slog.String("short_url", shortUrl), slog.Any("error", err))
+		} else {
+			response["claim_token"] = token
+		}
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// existingShortUrl responds to the creation of a link whose short code is taken by a live link. Creating the
+// same URL again for the same owner gives the same code: the existing link is returned unchanged, with a 200.
+// Any other link holding the code is left alone and reported as a conflict.
+func existingShortUrl(c *gin.Context, link *store.Link) {
+	existing, err := store.GetLink(c.Request.Context(), link.Code)
+	switch {
+	case err == nil && existing.Owner == link.Owner && existing.Destination == link.Destination:
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "short url already exists",
+			"short_url": config.ShortURL(existing.Code),
+			"link":      existing,
+		})
+	case err == nil, errors.Is(err, store.ErrNotFound), errors.Is(err, store.ErrExpired), errors.Is(err, store.ErrDeleted):
+		c.JSON(http.StatusConflict, gin.H{"error": "This short url is unavailable"})
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), "Failed to retrieve existing link", slog.String("short_url", link.Code), slog.Any("error", err))
+		serviceUnavailable(c)
+	default:
+		slog.ErrorContext(c.Request.Context(), "Failed to retrieve existing link", slog.String("short_url", link.Code), slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save url mapping"})
+	}
 }
 
 // HandleShortUrlRedirect is a Gin handler function that redirects the user to the original URL using the short URL as a parameter.
-// It retrieves the original URL from the store using the provided short URL, and then redirects the user to that URL.
+// It retrieves the link from the store using the provided short URL, and then redirects the user to its destination
+// with the link's redirect type. Unknown short URLs get a 404, expired and deleted ones a 410, disabled ones a 403 with the reason, as a page for browsers, and store
+// failures a 503 with a Retry-After header, so an outage of the store is not reported to users as missing links.
 func HandleShortUrlRedirect(c *gin.Context) {
 	// Extract the short URL from the request parameters.
 	shortUrl := c.Param("shortUrl")
 
-	// Retrieve the initial/original URL from the store using the short URL.
-	initialUrl := store.RetrieveInitialUrl(shortUrl)
-	if initialUrl == "" {
+	// Retrieve the link from the store using the short URL.
+	link, err := store.GetLink(c.Request.Context(), shortUrl)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
 		// If the mapping could not be found, return a Not Found response.
+		metrics.RecordRedirect(false)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Url not found"})
 		return
+	case errors.Is(err, store.ErrExpired):
+		metrics.RecordRedirect(false)
+		c.JSON(http.StatusGone, gin.H{"error": "Url expired"})
+		return
+	case errors.Is(err, store.ErrDeleted):
+		metrics.RecordRedirect(false)
+		c.JSON(http.StatusGone, gin.H{"error": "Url deleted"})
+		return
+	case err != nil:
+		metrics.RecordRedirectError()
+		slog.WarnContext(c.Request.Context(), "Failed to retrieve initial url", slog.String("short_url", shortUrl), slog.Any("error", err))
+		serviceUnavailable(c)
+		return
 	}
+	if link.Flags.Disabled {
+		metrics.RecordRedirect(false)
+		disabledLink(c, link)
+		return
+	}
+	metrics.RecordRedirect(true)
+	store.RecordClick(c.Request.Context(), link)
 
+	initialUrl := link.Destination
 	if !strings.HasPrefix(initialUrl, "http://") && !strings.HasPrefix(initialUrl, "https://") {
 		initialUrl = "https://" + initialUrl
 	}
@@ -71,32 +196,64 @@ func HandleShortUrlRedirect(c *gin.Context) {
 	// Trim any whitespace from the initial URL.
 	initialUrl = strings.TrimSpace(initialUrl)
 
-	// Redirect the user to the original URL with a 302 status code.
-	c.Redirect(http.StatusPermanentRedirect, initialUrl)
+	// Redirect the user to the original URL with the link's status code, or the configured default.
+	redirectCode := link.RedirectCode
+	if redirectCode == 0 {
+		redirectCode = config.Runtime().DefaultRedirectCode
+	}
+	c.Redirect(redirectCode, initialUrl)
 }
 
-// getClientIP retrieves the client's IP address from the request headers or remote address.
-// It prioritizes the "X-Forwarded-For" header, followed by the "X-Real-IP" header, and finally the remote address.
-func getClientIP(c *gin.Context) string {
-	// Check the "X-Forwarded-For" header for the client's IP address.
-	xForwardedFor := c.GetHeader("X-Forwarded-For")
-	if xForwardedFor != "" {
-		// Split the header value by commas and return the first IP address.
-		ips := strings.Split(xForwardedFor, ",")
-		return strings.TrimSpace(ips[0])
+// ValidateShortCode is a Gin middleware that answers 404 with the given error message for requests whose
+// short code, taken from the named path parameter, could not have been generated by the shortener.
+// It runs before any store access, since short codes are used as Redis keys as they are.
+func ValidateShortCode(param, message string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !shortener.ValidShortLink(c.Param(param)) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": message})
+			return
+		}
+		c.Next()
 	}
+}
 
-	// Check the "X-Real-IP" header for the client's IP address.
-	xRealIP := c.GetHeader("X-Real-IP")
-	if xRealIP != "" {
-		return xRealIP
+// serviceUnavailable responds with a 503 Service Unavailable and a Retry-After header,
+// for requests that failed because the store could not be reached.
+func serviceUnavailable(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(retryAfterSeconds))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service temporarily unavailable, please retry later"})
+}
+
+// requestOwner returns the owner of the links created and listed by a request:
+// the workspace selected by the request, as checked by Authorize, or else the requester.
+func requestOwner(c *gin.Context) string {
+	if owner := c.GetString(ownerContextKey); owner != "" {
+		return owner
 	}
+	return personalOwner(c)
+}
 
-	// If no headers are present, use the remote address from the request.
-	ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
-	if err != nil {
-		// Return the raw remote address if there's an error parsing it.
-		return c.Request.RemoteAddr
+// personalOwner returns the owner of the requester's own links: the logged-in user's ID, the subject
+// of the API token, or for anonymous requests a UUID generated from the client IP address.
+func personalOwner(c *gin.Context) string {
+	if user := currentUser(c); user != nil {
+		return user.ID
+	}
+	if claims := apiToken(c); claims != nil {
+		return claims.Subject
+	}
+	return utils.GenerateUUIDFromIP(c.ClientIP())
+}
+
+// requestActor returns the actor of the link mutations made by a request: the logged-in user,
+// the subject of the API token, or the anonymous client.
+func requestActor(c *gin.Context) store.Actor {
+	clientIP := c.ClientIP()
+	if user := currentUser(c); user != nil {
+		return store.Actor{Type: store.ActorUser, ID: user.ID, ClientIP: clientIP}
+	}
+	if claims := apiToken(c); claims != nil {
+		return store.Actor{Type: store.ActorToken, ID: claims.Subject, ClientIP: clientIP}
 	}
-	return ip
+	return store.Actor{Type: store.ActorAnonymous, ID: utils.GenerateUUIDFromIP(clientIP), ClientIP: clientIP}
 }
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/auth"
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/shortener"
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain loads the configuration and connects the store, which the handlers rely on.
+func TestMain(m *testing.M) {
+	config.LoadConfig()
+	gin.SetMode(gin.TestMode)
+	store.InitializeStoreService()
+	waitForStore()
+	code := m.Run()
+	_ = store.Close()
+	os.Exit(code)
+}
+
+// waitForStore waits for the store to connect in the background.
+func waitForStore() {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if store.Ping(context.Background()) == nil {
+			return
+		}
+	}
+}
+
+// serve sends a request with the given headers through a router and returns the recorded response.
+func serve(r http.Handler, method, path string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// withUser returns a middleware logging the request in as a user, as Authenticate does.
+func withUser(user *store.User) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if user != nil {
+			c.Set(userContextKey, user)
+		}
+	}
+}
+
+// withToken returns a middleware authenticating the request with API token claims, as Authenticate does.
+func withToken(claims *auth.TokenClaims) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(tokenContextKey, claims)
+	}
+}
+
+// respondOwner answers 200 with the owner resolved by Authorize.
+func respondOwner(c *gin.Context) {
+	c.String(http.StatusOK, requestOwner(c))
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	owner, editor, viewer, stranger := &store.User{ID: uuid.NewString()}, &store.User{ID: uuid.NewString()},
+		&store.User{ID: uuid.NewString()}, &store.User{ID: uuid.NewString()}
+	workspace := &store.Workspace{Name: "Handlers", CreatedBy: owner.ID}
+	require.NoError(t, store.CreateWorkspace(ctx, workspace))
+	for user, role := range map[*store.User]store.Role{editor: store.RoleEditor, viewer: store.RoleViewer} {
+		_, err := store.ChangeMember(ctx, workspace.ID, user.ID, func(store.Role) (store.Role, error) { return role, nil })
+		require.NoError(t, err)
+	}
+
+	router := func(user *store.User) *gin.Engine {
+		r := gin.New()
+		r.GET("/links", withUser(user), Authorize(store.PermissionViewLinks), respondOwner)
+		r.POST("/links", withUser(user), Authorize(store.PermissionEditLinks), respondOwner)
+		return r
+	}
+
+	// Members act on the workspace's links as far as their role allows.
+	w := serve(router(viewer), http.MethodGet, "/links", workspaceHeader, workspace.ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, workspace.ID, w.Body.String())
+	w = serve(router(viewer), http.MethodPost, "/links", workspaceHeader, workspace.ID)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router(editor), http.MethodPost, "/links", workspaceHeader, workspace.ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, workspace.ID, w.Body.String())
+
+	// Non-members are told the workspace does not exist, and anonymous requests must log in.
+	w = serve(router(stranger), http.MethodGet, "/links", workspaceHeader, workspace.ID)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(router(nil), http.MethodGet, "/links", workspaceHeader, workspace.ID)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Without a workspace, requests manage the requester's own links.
+	w = serve(router(stranger), http.MethodPost, "/links")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, stranger.ID, w.Body.String())
+
+	// Anonymous clients share their owner with everyone behind the same IP address, so they cannot change links.
+	w = serve(router(nil), http.MethodPost, "/links")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(router(nil), http.MethodGet, "/links")
+	assert.Equal(t, http.StatusOK, w.Code)
+	anonymous := gin.New()
+	anonymous.POST("/create", Authorize(store.PermissionCreateLinks), respondOwner)
+	w = serve(anonymous, http.MethodPost, "/create")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Body.String())
+}
+
+func TestRequireScope(t *testing.T) {
+	router := func(claims *auth.TokenClaims) *gin.Engine {
+		r := gin.New()
+		if claims != nil {
+			r.Use(withToken(claims))
+		}
+		r.POST("/links", RequireScope(auth.ScopeLinksWrite), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		return r
+	}
+
+	w := serve(router(&auth.TokenClaims{Scope: auth.ScopeLinksRead}), http.MethodPost, "/links")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+	w = serve(router(&auth.TokenClaims{Scope: auth.ScopeLinksRead + " " + auth.ScopeLinksWrite}), http.MethodPost, "/links")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// Requests not authenticated with a token are left to Authorize.
+	w = serve(router(nil), http.MethodPost, "/links")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestHandleShortUrlRedirect(t *testing.T) {
+	ctx := context.Background()
+	r := gin.New()
+	r.GET("/:shortUrl", ValidateShortCode("shortUrl", "Url not found"), HandleShortUrlRedirect)
+	newLink := func(destination string) *store.Link {
+		link := &store.Link{Code: shortener.GenerateShortLink(destination, uuid.NewString()), Destination: destination, Owner: "redirect-owner"}
+		require.NoError(t, store.SaveLink(ctx, link))
+		return link
+	}
+
+	active := newLink("https://example.com/active")
+	w := serve(r, http.MethodGet, "/"+active.Code)
+	assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+	assert.Equal(t, "https://example.com/active", w.Header().Get("Location"))
+
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/"+shortener.GenerateShortLink("missing", uuid.NewString())).Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/%7Bban:x%7D:ban").Code)
+
+	deleted := newLink("https://example.com/deleted")
+	_, err := store.DeleteLink(ctx, deleted.Owner, deleted.Code)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGone, serve(r, http.MethodGet, "/"+deleted.Code).Code)
+
+	// Disabled links show the reason, as a page for browsers.
+	disabled := newLink("https://example.com/disabled")
+	_, err = store.DisableLink(ctx, disabled.Code, "Phishing <b>")
+	require.NoError(t, err)
+	w = serve(r, http.MethodGet, "/"+disabled.Code)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error": "Url disabled", "reason": "Phishing <b>"}`, w.Body.String())
+	w = serve(r, http.MethodGet, "/"+disabled.Code, "Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"))
+	assert.Contains(t, w.Body.String(), "Reason: Phishing &lt;b&gt;")
+
+	// Store failures are reported as such, not as missing links. The link is not cached yet, so it is read from Redis.
+	unreachable := newLink("https://example.com/unreachable")
+	require.NoError(t, store.Close())
+	defer func() {
+		store.InitializeStoreService()
+		waitForStore()
+	}()
+	w = serve(r, http.MethodGet, "/"+unreachable.Code)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,339 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/shortener"
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+)
+
+// ListLinks is a Gin handler function that lists the links of the requesting owner, one page at a time.
+// Links can be filtered by tag, status, creation date range and a substring of their destination or title,
+// and sorted by any of the store's sort fields. The response holds the page of links and the cursor of
+// the next page, if any.
+//
+// Query parameters:
+//
+//	tag - a tag the links must have; may be repeated
+//	folder - the ID of the folder holding the links
+//	status - active, expired, disabled or deleted; links in the trash are only listed with deleted
+//	created_after, created_before - RFC 3339 bounds of the creation time
+//	q - case-insensitive substring of the destination or title
+//	sort - created_at (default), updated_at, expires_at, title or destination
+//	order - asc or desc (default)
+//	limit - page size, up to 100 (default 20)
+//	cursor - the next_cursor of the previous page
+func ListLinks(c *gin.Context) {
+	query, err := linkQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := store.ListLinks(c.Request.Context(), query)
+	switch {
+	case errors.Is(err, store.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+		return
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), "Failed to list links", slog.Any("error", err))
+		serviceUnavailable(c)
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Failed to list links", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list links"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// BulkUpdateRequest is the body of a bulk update: the codes of up to 1000 links to change, the tags to add
+// and remove, and optionally the folder to move the links to ("" takes them out of their folder).
+type BulkUpdateRequest struct {
+	Codes      []string `json:"codes" binding:"required,min=1,max=1000,dive,required"`
+	AddTags    []string `json:"add_tags" binding:"dive,required,max=50"`
+	RemoveTags []string `json:"remove_tags" binding:"dive,required,max=50"`
+	Folder     *string  `json:"folder"`
+}
+
+// BulkUpdateLinks is a Gin handler function that adds and removes tags, and optionally changes the folder,
+// of a selection of the requesting owner's links. Codes of unknown links, links owned by someone else,
+// and links in the trash are reported as not found; the other links are updated.
+func BulkUpdateLinks(c *gin.Context) {
+	var request BulkUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	owner := requestOwner(c)
+	if request.Folder != nil && *request.Folder != "" {
+		if _, err := store.GetFolder(c.Request.Context(), owner, *request.Folder); err != nil {
+			if errors.Is(err, store.ErrFolderNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "folder not found"})
+				return
+			}
+			folderError(c, err)
+			return
+		}
+	}
+
+	updated, notFound := []string{}, []string{}
+	for _, code := range request.Codes {
+		if !shortener.ValidShortLink(code) {
+			notFound = append(notFound, code)
+			continue
+		}
+		_, err := store.UpdateLink(c.Request.Context(), code, func(link *store.Link) error {
+			if link.Owner != owner || link.DeletedAt != nil {
+				return store.ErrNotFound
+			}
+			link.AddTags(request.AddTags...)
+			link.RemoveTags(request.RemoveTags...)
+			if request.Folder != nil {
+				link.Folder = *request.Folder
+			}
+			return nil
+		})
+		switch {
+		case err == nil:
+			updated = append(updated, code)
+		case errors.Is(err, store.ErrNotFound):
+			notFound = append(notFound, code)
+		case errors.Is(err, store.ErrUnavailable):
+			slog.WarnContext(c.Request.Context(), "Failed to update link", slog.String("short_url", code), slog.Any("error", err))
+			serviceUnavailable(c)
+			return
+		default:
+			slog.ErrorContext(c.Request.Context(), "Failed to update link", slog.String("short_url", code), slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update links", "updated": updated})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"updated": updated, "not_found": notFound})
+}
+
+// LinkUpdateRequest is the body of a link update: the destination and settings to change, the others
+// being left as they are. An expires_at of null removes the link's expiry.
+type LinkUpdateRequest struct {
+	LongUrl      *string      `json:"url" binding:"omitempty,min=1"`
+	Title        *string      `json:"title"`
+	ExpiresAt    optionalTime `json:"expires_at"`
+	RedirectCode *int         `json:"redirect_code" binding:"omitempty,oneof=301 302 307 308"`
+}
+
+// optionalTime is a time in a JSON body that can be left out, which leaves a setting unchanged,
+// or set to null, which clears it.
+type optionalTime struct {
+	Set   bool       // Whether the field was in the body.
+	Value *time.Time // Nil for null.
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It is only called for fields present in the body, null included.
+func (t *optionalTime) UnmarshalJSON(data []byte) error {
+	t.Set = true
+	if string(data) == "null" {
+		t.Value = nil
+		return nil
+	}
+	t.Value = new(time.Time)
+	return json.Unmarshal(data, t.Value)
+}
+
+// UpdateLink is a Gin handler function that changes the destination, title, expiry or redirect type of one
+// of the requesting owner's links. The previous version is kept in the link's history, the change is
+// recorded in the audit log, and redirects use the new destination right away on every replica.
+func UpdateLink(c *gin.Context) {
+	var request LinkUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.LongUrl == nil && request.Title == nil && !request.ExpiresAt.Set && request.RedirectCode == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update: set url, title, expires_at or redirect_code"})
+		return
+	}
+
+	owner := requestOwner(c)
+	link, err := store.UpdateLink(c.Request.Context(), c.Param("code"), func(link *store.Link) error {
+		if link.Owner != owner || link.DeletedAt != nil {
+			return store.ErrNotFound
+		}
+		if request.LongUrl != nil {
+			link.Destination = *request.LongUrl
+		}
+		if request.Title != nil {
+			link.Title = *request.Title
+		}
+		if request.ExpiresAt.Set {
+			link.ExpiresAt = request.ExpiresAt.Value
+		}
+		if request.RedirectCode != nil {
+			link.RedirectCode = *request.RedirectCode
+		}
+		return nil
+	})
+	if err != nil {
+		linkError(c, "Failed to update link", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"link": link})
+}
+
+// RollbackRequest is the body of a rollback: the version of the link to restore.
+type RollbackRequest struct {
+	Version int `json:"version" binding:"required,min=1"`
+}
+
+// LinkVersions is a Gin handler function that lists the versions of one of the requesting owner's links,
+// newest first. Each version holds the link as it was written, when and by whom.
+func LinkVersions(c *gin.Context) {
+	versions, err := store.ListLinkVersions(c.Request.Context(), requestOwner(c), c.Param("code"))
+	if err != nil {
+		linkError(c, "Failed to list link versions", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// RollbackLink is a Gin handler function that restores the destination and settings of one of the requesting
+// owner's links to those of a previous version. The rollback is saved as a new version, and takes effect
+// for redirects right away.
+func RollbackLink(c *gin.Context) {
+	var request RollbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	link, err := store.RollbackLink(c.Request.Context(), requestOwner(c), c.Param("code"), request.Version)
+	if err != nil {
+		linkError(c, "Failed to roll back link", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"link": link})
+}
+
+// DeleteLink is a Gin handler function that moves one of the requesting owner's links to the trash.
+// Its short URL answers 410 Gone from then on, and its code is not freed: the link can be restored until
+// it is purged after the trash retention, and its code is then quarantined before it can be reused.
+func DeleteLink(c *gin.Context) {
+	link, err := store.DeleteLink(c.Request.Context(), requestOwner(c), c.Param("code"))
+	if err != nil {
+		linkError(c, "Failed to delete link", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"link": link})
+}
+
+// RestoreLink is a Gin handler function that takes one of the requesting owner's links out of the trash.
+func RestoreLink(c *gin.Context) {
+	link, err := store.RestoreLink(c.Request.Context(), requestOwner(c), c.Param("code"))
+	if err != nil {
+		linkError(c, "Failed to restore link", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"link": link})
+}
+
+// LinkStats is a Gin handler function that aggregates the number of links and clicks of the requesting
+// owner's links by folder (the default) or by tag, as selected by the group_by query parameter.
+func LinkStats(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", store.GroupByFolder)
+	if groupBy != store.GroupByFolder && groupBy != store.GroupByTag {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be folder or tag"})
+		return
+	}
+
+	stats, err := store.LinkStats(c.Request.Context(), requestOwner(c), groupBy)
+	switch {
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), "Failed to aggregate link stats", slog.Any("error", err))
+		serviceUnavailable(c)
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Failed to aggregate link stats", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate link stats"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"group_by": groupBy, "groups": stats})
+}
+
+// linkError responds to a failure to read or change one of the requester's links.
+func linkError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
+	case errors.Is(err, store.ErrVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), message, slog.String("short_url", c.Param("code")), slog.Any("error", err))
+		serviceUnavailable(c)
+	default:
+		slog.ErrorContext(c.Request.Context(), message, slog.String("short_url", c.Param("code")), slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// linkQuery builds the store query of a link listing from the request's query parameters.
+func linkQuery(c *gin.Context) (store.LinkQuery, error) {
+	query := store.LinkQuery{
+		Owner:      requestOwner(c),
+		Tags:       c.QueryArray("tag"),
+		Folder:     c.Query("folder"),
+		Status:     c.Query("status"),
+		Search:     c.Query("q"),
+		SortBy:     c.DefaultQuery("sort", store.SortByCreatedAt),
+		Descending: c.DefaultQuery("order", "desc") == "desc",
+		Cursor:     c.Query("cursor"),
+	}
+
+	switch query.Status {
+	case "", store.LinkStatusActive, store.LinkStatusExpired, store.LinkStatusDisabled, store.LinkStatusDeleted:
+	default:
+		return query, errors.New("status must be active, expired, disabled or deleted")
+	}
+	switch query.SortBy {
+	case store.SortByCreatedAt, store.SortByUpdatedAt, store.SortByExpiresAt, store.SortByTitle, store.SortByDestination:
+	default:
+		return query, errors.New("sort must be created_at, updated_at, expires_at, title or destination")
+	}
+	if order := c.DefaultQuery("order", "desc"); order != "asc" && order != "desc" {
+		return query, errors.New("order must be asc or desc")
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > store.MaxListLimit {
+			return query, errors.New("limit must be between 1 and 100")
+		}
+		query.Limit = limit
+	}
+
+	var err error
+	if query.CreatedAfter, err = queryTime(c, "created_after"); err != nil {
+		return query, err
+	}
+	if query.CreatedBefore, err = queryTime(c, "created_before"); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+// queryTime parses an optional RFC 3339 time query parameter.
+func queryTime(c *gin.Context, name string) (time.Time, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, errors.New(name + " must be an RFC 3339 time")
+	}
+	return t, nil
+}
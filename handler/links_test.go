@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/shortener"
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateLink(t *testing.T) {
+	user := &store.User{ID: uuid.NewString()}
+	link := &store.Link{Code: shortener.GenerateShortLink("https://example.com/update", user.ID), Destination: "https://example.com/update", Owner: user.ID}
+	require.NoError(t, store.SaveLink(context.Background(), link))
+
+	r := gin.New()
+	r.PATCH("/links/:code", withUser(user), Authorize(store.PermissionEditLinks), UpdateLink)
+	patch := func(body string) (int, *store.Link) {
+		req := httptest.NewRequest(http.MethodPatch, "/links/"+link.Code, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response struct {
+			Link *store.Link `json:"link"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Link
+	}
+
+	expiresAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	code, updated := patch(`{"expires_at": "` + expiresAt.Format(time.RFC3339) + `"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.NotNil(t, updated.ExpiresAt)
+	assert.True(t, expiresAt.Equal(*updated.ExpiresAt))
+
+	// Fields left out are unchanged, and null removes the expiry.
+	code, updated = patch(`{"title": "Renamed"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Renamed", updated.Title)
+	require.NotNil(t, updated.ExpiresAt)
+	code, updated = patch(`{"expires_at": null}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Nil(t, updated.ExpiresAt)
+	stored, err := store.GetLink(context.Background(), link.Code)
+	require.NoError(t, err)
+	assert.Nil(t, stored.ExpiresAt)
+
+	code, _ = patch(`{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = patch(`{"expires_at": "tomorrow"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+)
+
+// disabledPage is the page shown to browsers instead of redirecting for disabled links.
+var disabledPage = template.Must(template.New("disabled").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link disabled</title>
+</head>
+<body>
+<h1>Link disabled</h1>
+<p>This short link has been disabled by the moderators of this service and no longer redirects.</p>
+{{if .}}<p>Reason: {{.}}</p>{{end}}
+</body>
+</html>
+`))
+
+// ReportRequest is the body of an abuse report: its category, and optionally details for the moderators.
+type ReportRequest struct {
+	Category string `json:"category" binding:"required,oneof=spam phishing malware illegal other"`
+	Details  string `json:"details" binding:"max=2000"`
+}
+
+// DisableRequest is the body of a link disabling or owner ban: the reason, shown to visitors of the links.
+type DisableRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// ResolveReportRequest is the body of the resolution of an abuse report, with an optional note.
+type ResolveReportRequest struct {
+	Resolution string `json:"resolution" binding:"required,oneof=actioned dismissed"`
+	Note       string `json:"note" binding:"max=2000"`
+}
+
+// disabledLink responds to a visit of a disabled link with a 403: an HTML page showing the reason
+// for browsers, or a JSON error for other clients.
+func disabledLink(c *gin.Context, link *store.Link) {
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) != gin.MIMEHTML {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Url disabled", "reason": link.Flags.DisabledReason})
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusForbidden)
+	if err := disabledPage.Execute(c.Writer, link.Flags.DisabledReason); err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed to render the disabled link page", slog.Any("error", err))
+	}
+}
+
+// ReportLink is a Gin handler function that files an abuse report about a link, for review by the moderators.
+// Anyone can report a link, once a day per client IP address.
+func ReportLink(c *gin.Context) {
+	var request ReportRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	shortUrl := c.Param("shortUrl")
+	_, err := store.GetLink(c.Request.Context(), shortUrl)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Url not found"})
+		return
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), "Failed to retrieve reported link", slog.String("short_url", shortUrl), slog.Any("error", err))
+		serviceUnavailable(c)
+		return
+	}
+	// Expired, deleted and disabled links can still be reported: their codes may be reused later.
+
+	report := &store.Report{Code: shortUrl, Category: request.Category, Details: request.Details, ClientIP: c.ClientIP()}
+	err = store.FileReport(c.Request.Context(), report)
+	switch {
+	case errors.Is(err, store.ErrDuplicateReport):
+		c.JSON(http.StatusConflict, gin.H{"error": "You already reported this link"})
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), "Failed to file report", slog.String("short_url", shortUrl), slog.Any("error", err))
+		serviceUnavailable(c)
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Failed to file report", slog.String("short_url", shortUrl), slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to file report"})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "report received", "report_id": report.ID})
+	}
+}
+
+// SearchLinks is a Gin handler function that searches the links of every owner for moderation, newest first,
+// one page at a time. The response holds the page of links and the cursor of the next page, if any.
+//
+// Query parameters:
+//
+//	domain - the domain of the destinations, subdomains included; required unless owner is given
+//	owner - the owner of the links
+//	status - active, expired, disabled or deleted; links in the trash are only listed with deleted
+//	limit - page size, up to 100 (default 20)
+//	cursor - the next_cursor of the previous page
+func SearchLinks(c *gin.Context) {
+	search := store.LinkSearch{
+		Domain: c.Query("domain"),
+		Owner:  c.Query("owner"),
+		Status: c.Query("status"),
+		Cursor: c.Query("cursor"),
+	}
+	var err error
+	switch search.Status {
+	case "", store.LinkStatusActive, store.LinkStatusExpired, store.LinkStatusDisabled, store.LinkStatusDeleted:
+	default:
+		err = errors.New("status must be active, expired, disabled or deleted")
+	}
+	if search.Owner == "" && store.NormalizeDomain(search.Domain) == "" {
+		err = errors.New("domain or owner is required")
+	}
+	if raw := c.Query("limit"); err == nil && raw != "" {
+		search.Limit, err = strconv.Atoi(raw)
+		if err != nil || search.Limit < 1 || search.Limit > store.MaxListLimit {
+			err = errors.New("limit must be between 1 and 100")
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := store.SearchLinks(c.Request.Context(), search)
+	switch {
+	case errors.Is(err, store.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+	case err != nil:
+		moderationError(c, "Failed to search links", err)
+	default:
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// DisableLink is a Gin handler function that disables a link: its short URL stops redirecting and shows
+// the reason instead. The link stays disabled if its owner is banned and unbanned later.
+func DisableLink(c *gin.Context) {
+	var request DisableRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	link, err := store.DisableLink(c.Request.Context(), c.Param("code"), request.Reason)
+	if err != nil {
+		linkError(c, "Failed to disable link", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"link": link})
+}
+
+// EnableLink is a Gin handler function that enables a disabled link, whatever disabled it.
+func EnableLink(c *gin.Context) {
+	link, err := store.EnableLink(c.Request.Context(), c.Param("code"))
+	if err != nil {
+		linkError(c, "Failed to enable link", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"link": link})
+}
+
+// GetBan is a Gin handler function that returns the ban of an owner, or 404 if the owner is not banned.
+func GetBan(c *gin.Context) {
+	ban, err := store.GetBan(c.Request.Context(), c.Param("owner"))
+	if err != nil {
+		moderationError(c, "Failed to read ban", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ban": ban})
+}
+
+// BanOwner is a Gin handler function that bans an owner (a user, workspace or anonymous client ID) from creating
+// links. Its links are disabled with the reason of the ban in the background, so it answers 202; the ban
+// returned by GetBan has links_pending set until they all are.
+func BanOwner(c *gin.Context) {
+	var request DisableRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ban, err := store.BanOwner(c.Request.Context(), c.Param("owner"), request.Reason)
+	if err != nil {
+		moderationError(c, "Failed to ban owner", err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"ban": ban})
+}
+
+// UnbanOwner is a Gin handler function that lifts the ban of an owner. The links the ban disabled are enabled
+// in the background, so it answers 202. Links disabled on their own account stay disabled.
+func UnbanOwner(c *gin.Context) {
+	if err := store.UnbanOwner(c.Request.Context(), c.Param("owner")); err != nil {
+		moderationError(c, "Failed to unban owner", err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// ListReports is a Gin handler function that lists abuse reports one page at a time: open reports oldest
+// first, as a review queue, or resolved reports newest first.
+//
+// Query parameters:
+//
+//	status - open (default) or resolved
+//	limit - page size, up to 100 (default 20)
+//	cursor - the next_cursor of the previous page
+func ListReports(c *gin.Context) {
+	status := c.DefaultQuery("status", store.ReportStatusOpen)
+	var err error
+	if status != store.ReportStatusOpen && status != store.ReportStatusResolved {
+		err = errors.New("status must be open or resolved")
+	}
+	limit := 0
+	if raw := c.Query("limit"); err == nil && raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > store.MaxListLimit {
+			err = errors.New("limit must be between 1 and 100")
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := store.ListReports(c.Request.Context(), status, limit, c.Query("cursor"))
+	switch {
+	case errors.Is(err, store.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+	case err != nil:
+		moderationError(c, "Failed to list reports", err)
+	default:
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// ResolveReport is a Gin handler function that resolves an abuse report as actioned or dismissed, taking it
+// out of the review queue. Dealing with the reported link is done separately, for example with DisableLink.
+func ResolveReport(c *gin.Context) {
+	var request ResolveReportRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	report, err := store.ResolveReport(c.Request.Context(), c.Param("id"), request.Resolution, request.Note)
+	if err != nil {
+		moderationError(c, "Failed to resolve report", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+// moderationError responds to a failed moderation request.
+func moderationError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotBanned):
+		c.JSON(http.StatusNotFound, gin.H{"error": "owner not banned"})
+	case errors.Is(err, store.ErrReportNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), message, slog.Any("error", err))
+		serviceUnavailable(c)
+	default:
+		slog.ErrorContext(c.Request.Context(), message, slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/auth"
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookieName is the name of the cookie binding a single sign-on login to the browser that started it,
+// so a callback cannot be replayed in someone else's browser to log them in as the attacker.
+const oidcStateCookieName = "oidc_state"
+
+// oidcLoginTTL is how long a user has to complete a single sign-on login at the identity provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// errNoEmailClaim is returned when a new user signs on without an email address to register.
+var errNoEmailClaim = errors.New("the identity provider did not share an email address")
+
+// OIDCLogin is a Gin handler function that starts a single sign-on login by redirecting to the identity provider.
+// The optional return_to query parameter is a local path to go back to once logged in.
+func OIDCLogin(c *gin.Context) {
+	provider := auth.OIDC()
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	login := &store.LoginState{ReturnTo: localPath(c.Query("return_to"))}
+	state, err := auth.RandomToken()
+	if err == nil {
+		login.Nonce, err = auth.RandomToken()
+	}
+	if err == nil {
+		login.Verifier, err = auth.RandomToken()
+	}
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, login.Nonce, login.Verifier)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Identity provider unavailable", slog.Any("error", err))
+		serviceUnavailable(c)
+		return
+	}
+	if err := store.SaveLoginState(c.Request.Context(), state, login, oidcLoginTTL); err != nil {
+		accountError(c, err)
+		return
+	}
+
+	setCookie(c, oidcStateCookieName, state, int(oidcLoginTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback is a Gin handler function that completes a single sign-on login when the identity provider
+// redirects back. It maps the identity to a user, creating one on first login, syncs the workspace roles
+// mapped to the user's groups, and starts a session.
+func OIDCCallback(c *gin.Context) {
+	provider := auth.OIDC()
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login failed at the identity provider: " + providerError})
+		return
+	}
+
+	state := c.Query("state")
+	cookieState, _ := c.Cookie(oidcStateCookieName)
+	setCookie(c, oidcStateCookieName, "", -1)
+	if state == "" || state != cookieState {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login was not started from this browser"})
+		return
+	}
+	login, err := store.TakeLoginState(c.Request.Context(), state)
+	if err != nil {
+		if errors.Is(err, store.ErrLoginStateNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Login expired, please start again"})
+			return
+		}
+		accountError(c, err)
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), login.Nonce, login.Verifier)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Single sign-on failed", slog.Any("error", err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login failed"})
+		return
+	}
+	user, err := identityUser(c, identity)
+	if err != nil {
+		identityError(c, err)
+		return
+	}
+	syncMappedWorkspaces(c, user, identity.Groups)
+
+	if user, err = logIn(c, user); err != nil {
+		accountError(c, err)
+		return
+	}
+	if login.ReturnTo != "" {
+		c.Redirect(http.StatusSeeOther, login.ReturnTo)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": newAccountView(user)})
+}
+
+// identityUser returns the user an external identity logs in as. An identity seen for the first time is
+// linked to the account registered with its email address if the provider verified it, or else to a new
+// account without password.
+func identityUser(c *gin.Context, identity *auth.Identity) (*store.User, error) {
+	ctx := c.Request.Context()
+	user, err := store.GetIdentityUser(ctx, identity.Issuer, identity.Subject)
+	if !errors.Is(err, store.ErrUserNotFound) {
+		return user, err
+	}
+
+	if identity.Email == "" {
+		return nil, errNoEmailClaim
+	}
+	if identity.EmailVerified {
+		user, err = store.GetUserByEmail(ctx, identity.Email)
+	}
+	if user == nil && (err == nil || errors.Is(err, store.ErrUserNotFound)) {
+		user = &store.User{Email: identity.Email}
+		err = store.CreateUser(ctx, user)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := store.LinkIdentity(ctx, identity.Issuer, identity.Subject, user.ID); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Linked external identity", slog.String("user_id", user.ID), slog.String("issuer", identity.Issuer))
+	return user, nil
+}
+
+// syncMappedWorkspaces brings a user's workspace roles in line with the roles oidc_workspace_mappings grants its
+// groups. Mappings raise roles, and lower or remove the roles they granted once the groups no longer map to them;
+// roles granted or changed by hand are never lowered. Failures are logged and do not fail the request.
+// Roles already in line are left alone, so it is cheap enough to run on every bearer token request.
+func syncMappedWorkspaces(c *gin.Context, user *store.User, groups []string) {
+	ctx := c.Request.Context()
+	mapped := map[string]store.Role{}
+	for _, entry := range config.AppConfig.OIDCWorkspaceMappings {
+		mapping, err := config.ParseWorkspaceMapping(entry)
+		if err == nil && slices.Contains(groups, mapping.Group) {
+			mapped[mapping.WorkspaceID] = store.MaxRole(mapped[mapping.WorkspaceID], store.Role(mapping.Role))
+		}
+	}
+	granted, err := store.MappedRoles(ctx, user.ID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to sync mapped workspace roles", slog.Any("error", err))
+		return
+	}
+
+	workspaceIDs := slices.Collect(maps.Keys(mapped))
+	for workspaceID := range granted {
+		if _, ok := mapped[workspaceID]; !ok {
+			workspaceIDs = append(workspaceIDs, workspaceID)
+		}
+	}
+	for _, workspaceID := range workspaceIDs {
+		if err := syncMappedRole(ctx, user.ID, workspaceID, granted[workspaceID], mapped[workspaceID]); err != nil {
+			slog.WarnContext(ctx, "Failed to sync mapped workspace role", slog.String("workspace_id", workspaceID), slog.Any("error", err))
+		}
+	}
+}
+
+// errMemberChanged aborts the sync of a mapped role changed meanwhile; the next sync picks up the change.
+var errMemberChanged = errors.New("member role changed meanwhile")
+
+// syncMappedRole brings a user's role in a workspace in line with the role its groups are mapped to, empty if none.
+// granted is the role mappings granted the user in the workspace before, empty if none; it is only theirs to
+// lower or remove while the user still holds it.
+func syncMappedRole(ctx context.Context, userID, workspaceID string, granted, mapped store.Role) error {
+	current, err := store.MemberRole(ctx, workspaceID, userID)
+	if err != nil && !errors.Is(err, store.ErrNotMember) {
+		return err
+	}
+	managed := granted != "" && current == granted
+	role := current
+	if managed || store.MaxRole(current, mapped) != current {
+		role = mapped
+	}
+
+	if role != current {
+		if role != "" {
+			if _, err := store.GetWorkspace(ctx, workspaceID); err != nil {
+				return err
+			}
+		}
+		_, err := store.ChangeMember(ctx, workspaceID, userID, func(latest store.Role) (store.Role, error) {
+			if latest != current {
+				return latest, errMemberChanged
+			}
+			return role, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Roles the mappings granted are recorded, so they can be lowered or removed later.
+	var record store.Role
+	if managed || role != current {
+		record = role
+	}
+	if record == granted {
+		return nil
+	}
+	return store.SetMappedRole(ctx, userID, workspaceID, record)
+}
+
+// authenticateOIDCToken identifies the user of a request carrying a bearer token issued by the identity provider.
+// It returns false after responding if the token is refused.
+func authenticateOIDCToken(c *gin.Context, provider *auth.Provider, rawToken string) bool {
+	identity, err := provider.VerifyBearerToken(c.Request.Context(), rawToken)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		slog.InfoContext(c.Request.Context(), "Refused bearer token", slog.Any("error", err))
+		unauthorizedToken(c, "Invalid token")
+		return false
+	}
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Identity provider unavailable", slog.Any("error", err))
+		serviceUnavailable(c)
+		return false
+	}
+	user, err := identityUser(c, identity)
+	if err != nil {
+		identityError(c, err)
+		return false
+	}
+	// Group changes at the provider take effect with the next token, as they do at the next login.
+	syncMappedWorkspaces(c, user, identity.Groups)
+	c.Set(userContextKey, user)
+	return true
+}
+
+// identityError responds to a failure to map an external identity to a user.
+func identityError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errNoEmailClaim):
+		c.JSON(http.StatusForbidden, gin.H{"error": "The identity provider did not share an email address"})
+	case errors.Is(err, store.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this unverified email address already exists"})
+	default:
+		accountError(c, err)
+	}
+}
+
+// localPath returns the given path if it is a path on this server, and an empty string otherwise,
+// so logins cannot be used to redirect users to other sites.
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncMappedWorkspaces(t *testing.T) {
+	ctx := context.Background()
+	workspace := &store.Workspace{Name: "Mapped", CreatedBy: uuid.NewString()}
+	require.NoError(t, store.CreateWorkspace(ctx, workspace))
+	mappings := config.AppConfig.OIDCWorkspaceMappings
+	config.AppConfig.OIDCWorkspaceMappings = []string{"marketing=" + workspace.ID + ":editor", "ignored=" + uuid.NewString() + ":admin"}
+	defer func() { config.AppConfig.OIDCWorkspaceMappings = mappings }()
+
+	grant := func(user *store.User, groups ...string) store.Role {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		syncMappedWorkspaces(c, user, groups)
+		role, _ := store.MemberRole(ctx, workspace.ID, user.ID)
+		return role
+	}
+
+	member := &store.User{ID: uuid.NewString()}
+	assert.Equal(t, store.Role(""), grant(member, "sales"))
+	assert.Equal(t, store.RoleEditor, grant(member, "marketing", "ignored"))
+
+	// Roles granted by mappings follow the mappings, down to leaving the workspace.
+	config.AppConfig.OIDCWorkspaceMappings = []string{"marketing=" + workspace.ID + ":viewer"}
+	assert.Equal(t, store.RoleViewer, grant(member, "marketing"))
+	assert.Equal(t, store.Role(""), grant(member, "sales"))
+
+	// Roles granted or changed by hand are never lowered.
+	assert.Equal(t, store.RoleViewer, grant(member, "marketing"))
+	_, err := store.ChangeMember(ctx, workspace.ID, member.ID, func(store.Role) (store.Role, error) { return store.RoleAdmin, nil })
+	require.NoError(t, err)
+	assert.Equal(t, store.RoleAdmin, grant(member, "marketing"))
+	assert.Equal(t, store.RoleAdmin, grant(member))
+}
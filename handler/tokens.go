@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/drunkleen/go-url-shortner/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// tokenContextKey is the key under which Authenticate stores the claims of the API token of a request.
+const tokenContextKey = "api_token"
+
+// RequireScope is a Gin middleware that refuses requests authenticated with an API token lacking a scope.
+// Requests authenticated otherwise are left to the permission checks of Authorize.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := apiToken(c); claims != nil && !claims.HasScope(scope) {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token lacks the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// authenticateBearer authenticates a request carrying a bearer token: a token issued by the identity provider
+// identifies a user, and an API token identifies the owner of the links it manages.
+// It returns false after responding if the token is refused.
+func authenticateBearer(c *gin.Context, rawToken string) bool {
+	issuer := strings.TrimSuffix(auth.TokenIssuer(rawToken), "/")
+	if provider := auth.OIDC(); provider != nil && issuer == provider.Issuer() {
+		return authenticateOIDCToken(c, provider, rawToken)
+	}
+
+	verifier := auth.APITokens()
+	if verifier == nil {
+		unauthorizedToken(c, "Bearer tokens are not accepted")
+		return false
+	}
+	claims, err := verifier.Verify(rawToken)
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "Refused API token", slog.Any("error", err))
+		unauthorizedToken(c, "Invalid token")
+		return false
+	}
+	c.Set(tokenContextKey, claims)
+	return true
+}
+
+// apiToken returns the claims of the API token of a request, or nil for requests authenticated otherwise.
+func apiToken(c *gin.Context) *auth.TokenClaims {
+	value, _ := c.Get(tokenContextKey)
+	claims, _ := value.(*auth.TokenClaims)
+	return claims
+}
+
+// unauthorizedToken responds with a 401 for a refused bearer token.
+func unauthorizedToken(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+}
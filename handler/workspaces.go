@@ -0,0 +1,309 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/store"
+	"github.com/gin-gonic/gin"
+)
+
+// workspaceHeader is the request header selecting the workspace whose links a request manages.
+// Without it, requests manage the links of the requester.
+const workspaceHeader = "X-Workspace-ID"
+
+// ownerContextKey is the key under which Authorize stores the owner of the links a request manages.
+const ownerContextKey = "owner"
+
+// errRoleNotAllowed is returned by member changes the requesting member's role does not allow.
+var errRoleNotAllowed = errors.New("role not allowed")
+
+// WorkspaceRequest is the body of workspace creation and update requests.
+type WorkspaceRequest struct {
+	Name string `json:"name" binding:"required,min=1,max=100"`
+}
+
+// MemberRequest is the body of member role changes.
+type MemberRequest struct {
+	Role store.Role `json:"role" binding:"required,oneof=owner admin editor viewer"`
+}
+
+// InvitationRequest is the body of invitation creation requests.
+type InvitationRequest struct {
+	Role store.Role `json:"role" binding:"required,oneof=owner admin editor viewer"`
+}
+
+// anonymousPermissions are the permissions of anonymous requests on the links of their client IP address.
+var anonymousPermissions = map[store.Permission]bool{store.PermissionCreateLinks: true, store.PermissionViewLinks: true}
+
+// Authorize is a Gin middleware that resolves the owner of the links a request manages and checks the
+// requester may do so. Requests naming a workspace in the X-Workspace-ID header need a logged-in member
+// of the workspace whose role has the permission; other requests manage the requester's own links.
+// Anonymous requests may only create and view links: their owner is derived from the client IP address,
+// which clients behind the same NAT or proxy share, so changing links requires a session or an API token.
+func Authorize(permission store.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceID := c.GetHeader(workspaceHeader)
+		if workspaceID == "" {
+			if !anonymousPermissions[permission] && currentUser(c) == nil && apiToken(c) == nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+				return
+			}
+			c.Set(ownerContextKey, personalOwner(c))
+			c.Next()
+			return
+		}
+		if _, ok := workspaceRole(c, workspaceID, permission); !ok {
+			c.Abort()
+			return
+		}
+		c.Set(ownerContextKey, workspaceID)
+		c.Next()
+	}
+}
+
+// CreateWorkspace is a Gin handler function that creates a workspace owned by the logged-in user.
+func CreateWorkspace(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	var request WorkspaceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspace := &store.Workspace{Name: request.Name, CreatedBy: user.ID}
+	if err := store.CreateWorkspace(c.Request.Context(), workspace); err != nil {
+		workspaceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, store.Membership{Workspace: workspace, Role: store.RoleOwner})
+}
+
+// ListWorkspaces is a Gin handler function that lists the workspaces of the logged-in user, with its role in each.
+func ListWorkspaces(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	memberships, err := store.ListUserWorkspaces(c.Request.Context(), user.ID)
+	if err != nil {
+		workspaceError(c, err)
+		return
+	}
+	if memberships == nil {
+		memberships = []store.Membership{}
+	}
+	c.JSON(http.StatusOK, gin.H{"workspaces": memberships})
+}
+
+// GetWorkspace is a Gin handler function that returns a workspace and its members to any of its members.
+func GetWorkspace(c *gin.Context) {
+	role, ok := workspaceRole(c, c.Param("id"), store.PermissionViewLinks)
+	if !ok {
+		return
+	}
+	workspace, err := store.GetWorkspace(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		workspaceError(c, err)
+		return
+	}
+	members, err := store.ListMembers(c.Request.Context(), workspace.ID)
+	if err != nil {
+		workspaceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"workspace": workspace, "role": role, "members": members})
+}
+
+// UpdateWorkspace is a Gin handler function that renames a workspace. Only owners may do so.
+func UpdateWorkspace(c *gin.Context) {
+	if _, ok := workspaceRole(c, c.Param("id"), store.PermissionManageWorkspace); !ok {
+		return
+	}
+	var request WorkspaceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspace, err := store.UpdateWorkspace(c.Request.Context(), c.Param("id"), func(w *store.Workspace) { w.Name = request.Name })
+	if err != nil {
+		workspaceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, workspace)
+}
+
+// SetMemberRole is a Gin handler function that changes the role of a member of a workspace.
+// Admins manage every role but the owner's, which only owners can grant or take away.
+func SetMemberRole(c *gin.Context) {
+	actorRole, ok := workspaceRole(c, c.Param("id"), store.PermissionManageMembers)
+	if !ok {
+		return
+	}
+	var request MemberRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := store.ChangeMember(c.Request.Context(), c.Param("id"), c.Param("user"), func(current store.Role) (store.Role, error) {
+		if current == "" {
+			// Users join through invitations.
+			return "", store.ErrNotMember
+		}
+		if !actorRole.CanAssign(current, request.Role) {
+			return "", errRoleNotAllowed
+		}
+		return request.Role, nil
+	})
+	if err != nil {
+		memberError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, store.Member{UserID: c.Param("user"), Role: role})
+}
+
+// RemoveMember is a Gin handler function that removes a member from a workspace.
+// Members may always leave; removing someone else takes the right to change their role.
+func RemoveMember(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	permission := store.PermissionManageMembers
+	leaving := c.Param("user") == user.ID
+	if leaving {
+		permission = store.PermissionViewLinks
+	}
+	actorRole, ok := workspaceRole(c, c.Param("id"), permission)
+	if !ok {
+		return
+	}
+
+	_, err := store.ChangeMember(c.Request.Context(), c.Param("id"), c.Param("user"), func(current store.Role) (store.Role, error) {
+		if current == "" {
+			return "", store.ErrNotMember
+		}
+		if !leaving && !actorRole.CanAssign(current, "") {
+			return "", errRoleNotAllowed
+		}
+		return "", nil
+	})
+	if err != nil {
+		memberError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CreateInvitation is a Gin handler function that creates an invitation to join a workspace with a role.
+// The returned token is shown only once, and can be accepted once by any logged-in user until it expires.
+func CreateInvitation(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	actorRole, ok := workspaceRole(c, c.Param("id"), store.PermissionManageMembers)
+	if !ok {
+		return
+	}
+	var request InvitationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !actorRole.CanAssign("", request.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your role cannot grant this role"})
+		return
+	}
+
+	invitation := &store.Invitation{WorkspaceID: c.Param("id"), Role: request.Role, InvitedBy: user.ID}
+	token, err := store.CreateInvitation(c.Request.Context(), invitation, config.AppConfig.InvitationTTL)
+	if err != nil {
+		workspaceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": token, "invitation": invitation})
+}
+
+// AcceptInvitation is a Gin handler function that makes the logged-in user a member of the workspace of an invitation.
+func AcceptInvitation(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	membership, err := store.AcceptInvitation(c.Request.Context(), c.Param("token"), user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrInvitationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found or expired"})
+			return
+		}
+		workspaceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, membership)
+}
+
+// workspaceRole returns the logged-in user's role in a workspace if it has a permission.
+// Otherwise it responds with the reason and returns false: 401 for anonymous requests, 404 for
+// non-members, so workspaces cannot be discovered, and 403 for members whose role is too low.
+func workspaceRole(c *gin.Context, workspaceID string, permission store.Permission) (store.Role, bool) {
+	user, ok := requireUser(c)
+	if !ok {
+		return "", false
+	}
+	role, err := store.MemberRole(c.Request.Context(), workspaceID, user.ID)
+	if err != nil {
+		workspaceError(c, err)
+		return "", false
+	}
+	if !role.Can(permission) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your role in this workspace does not allow this"})
+		return "", false
+	}
+	return role, true
+}
+
+// requireUser returns the logged-in user, or responds with a 401 and returns false for anonymous requests.
+func requireUser(c *gin.Context) (*store.User, bool) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return nil, false
+	}
+	return user, true
+}
+
+// memberError responds to a failed member change.
+func memberError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errRoleNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your role cannot change this member's role"})
+	case errors.Is(err, store.ErrLastOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": "A workspace must keep at least one owner"})
+	case errors.Is(err, store.ErrNotMember):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+	default:
+		workspaceError(c, err)
+	}
+}
+
+// workspaceError responds to a failed workspace operation.
+func workspaceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotMember), errors.Is(err, store.ErrWorkspaceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+	case errors.Is(err, store.ErrUnavailable):
+		slog.WarnContext(c.Request.Context(), "Workspace operation failed", slog.Any("error", err))
+		serviceUnavailable(c)
+	default:
+		slog.ErrorContext(c.Request.Context(), "Workspace operation failed", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Workspace operation failed"})
+	}
+}
@@ -0,0 +1,118 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkTimeout bounds how long a single readiness check may take.
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable. It returns nil when the dependency is healthy.
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of a single readiness check, as reported by /readyz.
+// The endpoint is unauthenticated, so errors are logged rather than reported: they may name internal hosts.
+type CheckResult struct {
+	Status    string  `json:"status"` // "ok" or "fail".
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// Report is the JSON body returned by /readyz.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+var (
+	mu     sync.RWMutex
+	checks = map[string]Check{}
+
+	// shuttingDown is set once the server starts draining, so load balancers stop routing new traffic to it.
+	shuttingDown atomic.Bool
+)
+
+// SetShuttingDown marks the service as shutting down, which makes every readiness check fail.
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// Register adds a named readiness check, replacing any check previously registered under that name.
+func Register(name string, check Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	checks[name] = check
+}
+
+// Run executes every registered readiness check concurrently and returns the combined report.
+// The report status is "ok" only if every check passed and the service is not shutting down.
+func Run(ctx context.Context) Report {
+	mu.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	snapshot := make([]Check, len(names))
+	for i, name := range names {
+		snapshot[i] = checks[name]
+	}
+	mu.RUnlock()
+
+	// Run the checks in parallel so one slow dependency does not delay the others.
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i, check := range snapshot {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(checkCtx)
+			results[i] = CheckResult{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Status = "fail"
+				slog.WarnContext(ctx, "Readiness check failed", slog.String("check", names[i]), slog.Any("error", err))
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: "ok", Checks: make(map[string]CheckResult, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != "ok" {
+			report.Status = "unavailable"
+		}
+	}
+	if shuttingDown.Load() {
+		report.Status = "shutting_down"
+	}
+	return report
+}
+
+// Liveness is a Gin handler for /healthz. It reports that the process is alive and serving requests,
+// without checking any dependency.
+func Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readiness is a Gin handler for /readyz. It runs every registered check and responds with
+// 200 if all of them pass, or 503 otherwise, with the status of each dependency.
+func Readiness(c *gin.Context) {
+	report := Run(c.Request.Context())
+	if report.Status != "ok" {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
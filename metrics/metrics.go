@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric exposed by the service.
+const namespace = "url_shortener"
+
+// unmatchedRoute is the route label used for requests that did not match any registered route.
+const unmatchedRoute = "unmatched"
+
+var (
+	// Registry holds every metric exposed on /metrics, including the Go runtime and process collectors.
+	Registry = prometheus.NewRegistry()
+
+	// httpRequests counts handled HTTP requests per route, method and status.
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests handled, by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	// httpRequestDuration tracks HTTP request latency per route, method and status.
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency in seconds, by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// redirects counts short URL lookups by result (hit, miss or error).
+	redirects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Total number of short URL redirect lookups, by result (hit, miss or error).",
+	}, []string{"result"})
+
+	// linkCacheLookups counts lookups in the in-process link cache by result.
+	linkCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "link_cache_lookups_total",
+		Help:      "Total number of in-process link cache lookups, by result (hit, negative_hit or miss).",
+	}, []string{"result"})
+
+	// bloomFilterChecks counts Bloom filter checks of short codes by result (absent or present).
+	bloomFilterChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bloom_filter_checks_total",
+		Help:      "Total number of short codes checked against the Bloom filter, by result (absent or present).",
+	}, []string{"result"})
+
+	// bloomFilterFalsePositives counts codes the Bloom filter reported as present that the store did not have.
+	bloomFilterFalsePositives = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bloom_filter_false_positives_total",
+		Help:      "Total number of short codes reported as present by the Bloom filter but missing from the store.",
+	})
+
+	// bloomFilterFPRate is the false-positive rate of the Bloom filter estimated from its fill ratio.
+	bloomFilterFPRate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bloom_filter_estimated_false_positive_rate",
+		Help:      "False-positive rate of the Bloom filter of existing short codes, estimated from its fill ratio.",
+	})
+
+	// storeOperationDuration tracks store operation latency per operation.
+	storeOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Store operation latency in seconds, by operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	// storeOperationErrors counts failed store operations per operation.
+	storeOperationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_operation_errors_total",
+		Help:      "Total number of failed store operations, by operation.",
+	}, []string{"operation"})
+
+	// linksCreated counts short links successfully created.
+	linksCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_created_total",
+		Help:      "Total number of short links created.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpRequestDuration,
+		redirects,
+		linkCacheLookups,
+		bloomFilterChecks,
+		bloomFilterFalsePositives,
+		bloomFilterFPRate,
+		storeOperationDuration,
+		storeOperationErrors,
+		linksCreated,
+	)
+}
+
+// Middleware is a Gin middleware that records the request count and latency of every request.
+// Requests are labelled by their route template (e.g. "/:shortUrl") rather than the raw path,
+// so random short codes do not create new time series.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpRequestDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler returns a Gin handler serving the metrics in the Prometheus text exposition format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(HTTPHandler())
+}
+
+// HTTPHandler returns a plain http.Handler serving the metrics, for use on a separate admin port.
+func HTTPHandler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RecordRedirect records the result of a short URL lookup in the redirect path.
+func RecordRedirect(hit bool) {
+	if hit {
+		redirects.WithLabelValues("hit").Inc()
+	} else {
+		redirects.WithLabelValues("miss").Inc()
+	}
+}
+
+// RecordCacheLookup records the result of a lookup in the in-process link cache:
+// "hit", "negative_hit" for a code remembered as missing, or "miss".
+func RecordCacheLookup(result string) {
+	linkCacheLookups.WithLabelValues(result).Inc()
+}
+
+// RecordBloomFilterCheck records whether the Bloom filter reported a short code as possibly present.
+func RecordBloomFilterCheck(present bool) {
+	if present {
+		bloomFilterChecks.WithLabelValues("present").Inc()
+	} else {
+		bloomFilterChecks.WithLabelValues("absent").Inc()
+	}
+}
+
+// RecordBloomFilterFalsePositive records a short code the Bloom filter reported as present but the store did not have.
+func RecordBloomFilterFalsePositive() {
+	bloomFilterFalsePositives.Inc()
+}
+
+// SetBloomFilterFalsePositiveRate sets the estimated false-positive rate of the Bloom filter.
+func SetBloomFilterFalsePositiveRate(rate float64) {
+	bloomFilterFPRate.Set(rate)
+}
+
+// RecordRedirectError records a short URL lookup in the redirect path that failed because the store was unavailable.
+func RecordRedirectError() {
+	redirects.WithLabelValues("error").Inc()
+}
+
+// RecordLinkCreated records a successfully created short link.
+func RecordLinkCreated() {
+	linksCreated.Inc()
+}
+
+// ObserveStore records the latency of a store operation that started at the given time,
+// and counts it as an error if err is not nil.
+func ObserveStore(operation string, start time.Time, err error) {
+	storeOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		storeOperationErrors.WithLabelValues(operation).Inc()
+	}
+}
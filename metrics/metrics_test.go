@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/metrics", Handler())
+	r.GET("/:shortUrl", func(c *gin.Context) {
+		RecordRedirect(false)
+		c.Status(http.StatusNotFound)
+	})
+
+	// Generate some traffic and store activity.
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abc12345", nil))
+	ObserveStore("get", time.Now(), errors.New("connection refused"))
+	RecordLinkCreated()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, body, `url_shortener_http_requests_total{method="GET",route="/:shortUrl",status="404"} 1`)
+	assert.Contains(t, body, `url_shortener_redirects_total{result="miss"} 1`)
+	assert.Contains(t, body, `url_shortener_store_operation_errors_total{operation="get"} 1`)
+	assert.Contains(t, body, `url_shortener_links_created_total 1`)
+	assert.Contains(t, body, "go_goroutines")
+}
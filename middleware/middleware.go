@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxRequestIDLength caps the length of client-supplied request IDs so they cannot flood the logs.
+const maxRequestIDLength = 128
+
+// RequestID is a Gin middleware that assigns every request an ID.
+// It reuses the client's X-Request-ID header when present, otherwise generates a new UUID,
+// echoes the ID back in the response header and stores it in the request context for logging.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(logger.RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.NewString()
+		}
+
+		// Make the ID available to handlers and to every log line written with the request context.
+		c.Request = c.Request.WithContext(logger.WithRequestID(c.Request.Context(), requestID))
+		c.Header(logger.RequestIDHeader, requestID)
+
+		c.Next()
+	}
+}
+
+// Logger is a Gin middleware that writes one structured log line per request.
+// It replaces Gin's default logger so access logs share the application's format and request IDs.
+func Logger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []any{
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", c.Writer.Size()),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+
+		// Pick the log level based on the response status.
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		slog.Log(c.Request.Context(), level, "request handled", attrs...)
+	}
+}
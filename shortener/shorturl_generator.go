@@ -6,10 +6,17 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"strings"
 
 	"github.com/itchyny/base58-go"
 )
 
+// ShortLinkLength is the number of characters of the short links generated by GenerateShortLink.
+const ShortLinkLength = 8
+
+// base58Alphabet is the alphabet of the Bitcoin Base58 encoding, the only characters of short links.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
 // sha256Of computes the SHA256 checksum of the given input string.
 // It uses the crypto/sha256 package to compute the checksum, and
 // returns the result as a byte slice.
@@ -50,3 +57,18 @@ func GenerateShortLink(initialLink, userId string) string {
 	// Return the first 8 characters of the encoded string as the short link.
 	return finialStr[:8]
 }
+
+// ValidShortLink reports whether a short link could have been generated by GenerateShortLink: ShortLinkLength
+// Base58 characters. Short links from requests must pass it before being looked up, so that they can never
+// name the store's internal records, whose keys contain characters outside the Base58 alphabet.
+func ValidShortLink(shortLink string) bool {
+	if len(shortLink) != ShortLinkLength {
+		return false
+	}
+	for _, r := range shortLink {
+		if !strings.ContainsRune(base58Alphabet, r) {
+			return false
+		}
+	}
+	return true
+}
@@ -22,3 +22,14 @@ func TestShortLinkGenerator(t *testing.T) {
 	assert.Equal(t, shortLink_2, "6uUfWi2b")
 	assert.Equal(t, shortLink_3, "LGNFLMUN")
 }
+
+func TestValidShortLink(t *testing.T) {
+	assert.True(t, ValidShortLink(GenerateShortLink("https://example.com", UserId)))
+	assert.True(t, ValidShortLink("cWeetHYM"))
+	assert.False(t, ValidShortLink(""))
+	assert.False(t, ValidShortLink("cWeetHY"))
+	assert.False(t, ValidShortLink("cWeetHYMx"))
+	assert.False(t, ValidShortLink("cWeetHY0")) // 0, O, I and l are not Base58 characters.
+	assert.False(t, ValidShortLink("{ban:x}:"))
+	assert.False(t, ValidShortLink("abc:defg"))
+}
@@ -0,0 +1,309 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/logger"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/redis/go-redis/v9"
+)
+
+// Audit actions, one per kind of link mutation.
+const (
+	AuditActionCreate   = "create"
+	AuditActionUpdate   = "update"
+	AuditActionDelete   = "delete"
+	AuditActionTransfer = "transfer" // The link changed owner.
+	AuditActionRestore  = "restore"  // The link was restored from the trash.
+	AuditActionPurge    = "purge"    // The link was removed from the trash for good.
+	AuditActionDisable  = "disable"  // The link was disabled by a moderator or the ban of its owner.
+	AuditActionEnable   = "enable"   // The link was enabled again.
+)
+
+// Types of actors of link mutations.
+const (
+	ActorUser      = "user"      // A logged-in user, by ID.
+	ActorToken     = "token"     // An API token, by subject.
+	ActorAnonymous = "anonymous" // An anonymous client, by the UUID generated from its IP address.
+	ActorAdmin     = "admin"     // The admin API.
+	ActorSystem    = "system"    // The service itself, such as a background job.
+)
+
+// auditBatchSize is the number of audit events read from Redis per call while scanning the audit log.
+const auditBatchSize = 500
+
+// Actor is who or what mutates links. Handlers attach it to the request context with WithActor,
+// and the store records it in the audit event of each mutation.
+type Actor struct {
+	Type     string `json:"actor_type"` // One of the Actor constants.
+	ID       string `json:"actor,omitempty"`
+	ClientIP string `json:"client_ip,omitempty"`
+}
+
+// AuditEvent records a mutation of a link: who made it, when, and the link before and after.
+type AuditEvent struct {
+	ID     string    `json:"id"` // Unique and increasing with time; used as a cursor.
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"` // One of the AuditAction constants.
+	Code   string    `json:"code"`
+	Actor
+	RequestID string `json:"request_id,omitempty"`
+	Before    *Link  `json:"before,omitempty"` // Nil for created links.
+	After     *Link  `json:"after,omitempty"`  // Nil for purged links.
+}
+
+// AuditQuery selects audit events. Zero values disable the corresponding filter.
+type AuditQuery struct {
+	Code   string
+	Actor  string // ID of the actor.
+	Action string
+	Since  time.Time // Inclusive.
+	Until  time.Time // Exclusive.
+	Limit  int       // Defaults to DefaultListLimit, capped at MaxListLimit; ignored by exports.
+	Cursor string    // NextCursor of the previous page.
+}
+
+// AuditPage is a page of audit events, newest first. NextCursor is empty on the last page.
+type AuditPage struct {
+	Events     []*AuditEvent `json:"events"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// actorContextKey is the context key of the actor of a request.
+type actorContextKey struct{}
+
+// WithActor returns a copy of ctx carrying the actor of the link mutations made with it.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// actorFrom returns the actor carried by ctx. Mutations made without one are attributed to the system.
+func actorFrom(ctx context.Context) Actor {
+	if actor, ok := ctx.Value(actorContextKey{}).(Actor); ok {
+		return actor
+	}
+	return Actor{Type: ActorSystem}
+}
+
+// auditLogKey returns the key of the audit log, a Redis stream appended to by every link mutation.
+func auditLogKey() string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "audit}:events"
+}
+
+// updateAction returns the audit action of an update of a link.
+func updateAction(before, after *Link) string {
+	switch {
+	case before.DeletedAt == nil && after.DeletedAt != nil:
+		return AuditActionDelete
+	case before.DeletedAt != nil && after.DeletedAt == nil:
+		return AuditActionRestore
+	case !before.Flags.Disabled && after.Flags.Disabled:
+		return AuditActionDisable
+	case before.Flags.Disabled && !after.Flags.Disabled:
+		return AuditActionEnable
+	case before.Owner != after.Owner:
+		return AuditActionTransfer
+	default:
+		return AuditActionUpdate
+	}
+}
+
+// encodeAuditEvent returns the audit event of a link mutation made with ctx, encoded for the audit log.
+func encodeAuditEvent(reqCtx context.Context, action string, before, after *Link) (*AuditEvent, string, error) {
+	event := &AuditEvent{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		Actor:     actorFrom(reqCtx),
+		RequestID: logger.RequestID(reqCtx),
+		Before:    before,
+		After:     after,
+	}
+	if after != nil {
+		event.Code = after.Code
+	} else {
+		event.Code = before.Code
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", fmt.Errorf("encoding audit event of %q: %w", event.Code, err)
+	}
+	return event, string(value), nil
+}
+
+// auditInTransaction reports whether audit events are appended in the transaction of the link mutation
+// they record, so a mutation is never stored without its event. The audit log lives on a slot of its own
+// in Cluster mode, where a transaction cannot span it and a link, so events are appended right after it.
+func auditInTransaction() bool {
+	return config.AppConfig.RedisMode != RedisModeCluster
+}
+
+// queueAudit queues the command appending an encoded audit event to the audit log on the transaction
+// of the link mutation it records, unless auditInTransaction is false.
+func queueAudit(reqCtx context.Context, pipe redis.Pipeliner, value string) {
+	if auditInTransaction() {
+		pipe.XAdd(reqCtx, auditLogArgs(value))
+	}
+}
+
+// recordAudit appends the audit event of a link mutation to the audit log after the mutation, in Cluster
+// mode, where queueAudit cannot. The mutation has already happened, so a failure is logged rather than
+// returned, and the event is recorded even if the request was canceled meanwhile.
+func recordAudit(reqCtx context.Context, event *AuditEvent, value string) {
+	if auditInTransaction() {
+		return
+	}
+	auditCtx, cancel := withTimeout(context.WithoutCancel(reqCtx))
+	defer cancel()
+
+	start := time.Now()
+	err := storeService.redisClient.XAdd(auditCtx, auditLogArgs(value)).Err()
+	metrics.ObserveStore("record_audit", start, err)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Failed to record audit event", slog.String("short_url", event.Code),
+			slog.String("action", event.Action), slog.Any("error", err))
+	}
+}
+
+// auditLogArgs returns the arguments appending an encoded audit event to the audit log, trimming it to
+// the configured size, if any.
+func auditLogArgs(value string) *redis.XAddArgs {
+	args := &redis.XAddArgs{Stream: auditLogKey(), Values: []any{"event", value}}
+	if maxEvents := config.AppConfig.AuditLogMaxEvents; maxEvents > 0 {
+		args.MaxLen, args.Approx = int64(maxEvents), true
+	}
+	return args
+}
+
+// ListAuditEvents returns a page of the audit events matching the query, newest first.
+// Returns ErrInvalidCursor for a malformed cursor.
+func ListAuditEvents(reqCtx context.Context, query AuditQuery) (*AuditPage, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.ListAuditEvents")
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	limit = min(limit, MaxListLimit)
+
+	page := &AuditPage{Events: []*AuditEvent{}}
+	start := time.Now()
+	err := scanAuditEvents(reqCtx, query, true, func(event *AuditEvent) bool {
+		page.Events = append(page.Events, event)
+		if len(page.Events) < limit {
+			return true
+		}
+		page.NextCursor = event.ID
+		return false
+	})
+	metrics.ObserveStore("list_audit_events", start, ignore(err, ErrInvalidCursor))
+	tracing.End(span, ignore(err, ErrInvalidCursor))
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// ExportAuditEvents calls emit with every audit event matching the query, oldest first, until emit fails.
+// Returns the error of emit, ErrInvalidCursor for a malformed cursor, or an error wrapping ErrUnavailable.
+func ExportAuditEvents(reqCtx context.Context, query AuditQuery, emit func(*AuditEvent) error) error {
+	reqCtx, span := tracing.Start(reqCtx, "store.ExportAuditEvents")
+	var emitErr error
+	err := scanAuditEvents(reqCtx, query, false, func(event *AuditEvent) bool {
+		emitErr = emit(event)
+		return emitErr == nil
+	})
+	if err == nil {
+		err = emitErr
+	}
+	tracing.End(span, ignore(err, ErrInvalidCursor))
+	return err
+}
+
+// scanAuditEvents calls visit with the audit events matching the query, in order of time or in reverse,
+// until visit returns false. The log is read in batches, each with its own store timeout, so long scans
+// do not run out of time.
+func scanAuditEvents(reqCtx context.Context, query AuditQuery, reverse bool, visit func(*AuditEvent) bool) error {
+	low, high := "-", "+"
+	if !query.Since.IsZero() {
+		low = strconv.FormatInt(query.Since.UnixMilli(), 10)
+	}
+	if !query.Until.IsZero() {
+		high = strconv.FormatInt(query.Until.UnixMilli()-1, 10)
+	}
+	if query.Cursor != "" {
+		if !validStreamID(query.Cursor) {
+			return ErrInvalidCursor
+		}
+		if reverse {
+			high = "(" + query.Cursor
+		} else {
+			low = "(" + query.Cursor
+		}
+	}
+
+	for {
+		batchCtx, cancel := withTimeout(reqCtx)
+		var messages []redis.XMessage
+		var err error
+		if reverse {
+			messages, err = storeService.redisClient.XRevRangeN(batchCtx, auditLogKey(), high, low, auditBatchSize).Result()
+		} else {
+			messages, err = storeService.redisClient.XRangeN(batchCtx, auditLogKey(), low, high, auditBatchSize).Result()
+		}
+		cancel()
+		if err != nil {
+			return storeError(err)
+		}
+
+		for _, message := range messages {
+			event, err := decodeAuditEvent(message)
+			if err != nil {
+				return err
+			}
+			if query.matches(event) && !visit(event) {
+				return nil
+			}
+		}
+		if len(messages) < auditBatchSize {
+			return nil
+		}
+		if last := messages[len(messages)-1].ID; reverse {
+			high = "(" + last
+		} else {
+			low = "(" + last
+		}
+	}
+}
+
+// matches reports whether an audit event passes the query's filters, other than its time range.
+func (q AuditQuery) matches(event *AuditEvent) bool {
+	return (q.Code == "" || event.Code == q.Code) &&
+		(q.Actor == "" || event.Actor.ID == q.Actor) &&
+		(q.Action == "" || event.Action == q.Action)
+}
+
+// decodeAuditEvent parses an entry of the audit log.
+func decodeAuditEvent(message redis.XMessage) (*AuditEvent, error) {
+	value, _ := message.Values["event"].(string)
+	var event AuditEvent
+	if err := json.Unmarshal([]byte(value), &event); err != nil {
+		return nil, fmt.Errorf("decoding audit event %s: %w", message.ID, err)
+	}
+	event.ID = message.ID
+	return &event, nil
+}
+
+// validStreamID reports whether id is a complete Redis stream entry ID, such as 1700000000000-0.
+func validStreamID(id string) bool {
+	ms, seq, ok := strings.Cut(id, "-")
+	_, msErr := strconv.ParseUint(ms, 10, 64)
+	_, seqErr := strconv.ParseUint(seq, 10, 64)
+	return ok && msErr == nil && seqErr == nil
+}
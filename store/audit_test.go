@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/logger"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	code := fmt.Sprintf("audit-%d", time.Now().UnixNano())
+	since := time.Now().Add(-time.Second)
+
+	actor := Actor{Type: ActorUser, ID: "audit-user", ClientIP: "203.0.113.7"}
+	ctx := logger.WithRequestID(WithActor(context.Background(), actor), "request-1")
+	require.NoError(t, SaveLink(ctx, &Link{Code: code, Destination: "https://example.com/old", Owner: "audit-user"}))
+	_, err = UpdateLink(ctx, code, func(l *Link) error {
+		l.Destination = "https://example.com/new"
+		return nil
+	})
+	require.NoError(t, err)
+	// Mutations made without an actor are attributed to the system.
+	_, err = UpdateLink(context.Background(), code, func(l *Link) error {
+		l.Owner = "another-owner"
+		return nil
+	})
+	require.NoError(t, err)
+
+	page, err := ListAuditEvents(context.Background(), AuditQuery{Code: code})
+	require.NoError(t, err)
+	require.Len(t, page.Events, 3)
+	assert.Empty(t, page.NextCursor)
+
+	transfer, update, create := page.Events[0], page.Events[1], page.Events[2]
+	assert.Equal(t, AuditActionCreate, create.Action)
+	assert.Nil(t, create.Before)
+	assert.Equal(t, "https://example.com/old", create.After.Destination)
+	assert.Equal(t, actor, create.Actor)
+	assert.Equal(t, "request-1", create.RequestID)
+
+	assert.Equal(t, AuditActionUpdate, update.Action)
+	assert.Equal(t, "https://example.com/old", update.Before.Destination)
+	assert.Equal(t, "https://example.com/new", update.After.Destination)
+
+	assert.Equal(t, AuditActionTransfer, transfer.Action)
+	assert.Equal(t, "audit-user", transfer.Before.Owner)
+	assert.Equal(t, "another-owner", transfer.After.Owner)
+	assert.Equal(t, Actor{Type: ActorSystem}, transfer.Actor)
+	assert.Empty(t, transfer.RequestID)
+
+	// Filters and pagination.
+	page, err = ListAuditEvents(context.Background(), AuditQuery{Code: code, Actor: "audit-user", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, update.ID, page.Events[0].ID)
+	page, err = ListAuditEvents(context.Background(), AuditQuery{Code: code, Actor: "audit-user", Limit: 1, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, create.ID, page.Events[0].ID)
+
+	page, err = ListAuditEvents(context.Background(), AuditQuery{Code: code, Action: AuditActionTransfer, Since: since})
+	require.NoError(t, err)
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, transfer.ID, page.Events[0].ID)
+	page, err = ListAuditEvents(context.Background(), AuditQuery{Code: code, Until: since})
+	require.NoError(t, err)
+	assert.Empty(t, page.Events)
+
+	_, err = ListAuditEvents(context.Background(), AuditQuery{Cursor: "not-a-cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	// Exports are in order of time, and can resume after an event.
+	var exported []string
+	err = ExportAuditEvents(context.Background(), AuditQuery{Code: code}, func(event *AuditEvent) error {
+		exported = append(exported, event.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{create.ID, update.ID, transfer.ID}, exported)
+
+	exported = nil
+	err = ExportAuditEvents(context.Background(), AuditQuery{Code: code, Cursor: create.ID}, func(event *AuditEvent) error {
+		exported = append(exported, event.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{update.ID, transfer.ID}, exported)
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrClaimNotFound is returned for claim tokens that are unknown, expired or already used, or whose link
+// changed owner since it was created.
+var ErrClaimNotFound = errors.New("claim not found")
+
+// Claim lets the client that created a link anonymously move it to an account later, once.
+// Anonymous owners are shared by every client behind the same IP address, so owning a link anonymously
+// is not enough to claim it: the client must also hold the token returned when the link was created.
+type Claim struct {
+	Code      string    `json:"code"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// claimKey returns the key of a claim. Like sessions, claims are stored under a hash of their token.
+func claimKey(token string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "claim:" + tokenHash(token) + "}:claim"
+}
+
+// CreateClaim stores a claim on an anonymous link, valid for the given duration, and returns its token.
+func CreateClaim(reqCtx context.Context, link *Link, ttl time.Duration) (string, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	value, err := json.Marshal(Claim{Code: link.Code, Owner: link.Owner, CreatedAt: now, ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	err = storeService.redisClient.Set(reqCtx, claimKey(token), value, ttl).Err()
+	metrics.ObserveStore("create_claim", start, err)
+	if err != nil {
+		return "", storeError(err)
+	}
+	return token, nil
+}
+
+// ClaimLink uses up a claim and moves its link to a new owner. The link leaves its folder, which belongs
+// to the previous owner. The token is only used up once the link is moved, so a failed claim can be retried.
+// Returns the moved link, or ErrClaimNotFound.
+func ClaimLink(reqCtx context.Context, token, owner string) (*Link, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.ClaimLink")
+	link, err := claimLink(reqCtx, token, owner)
+	tracing.End(span, ignore(err, ErrClaimNotFound))
+	return link, err
+}
+
+// claimLink implements ClaimLink.
+func claimLink(reqCtx context.Context, token, owner string) (*Link, error) {
+	getCtx, cancel := withTimeout(reqCtx)
+	start := time.Now()
+	value, err := storeService.redisClient.Get(getCtx, claimKey(token)).Result()
+	metrics.ObserveStore("get_claim", start, ignore(err, redis.Nil))
+	cancel()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrClaimNotFound
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var claim Claim
+	if err := json.Unmarshal([]byte(value), &claim); err != nil {
+		return nil, fmt.Errorf("decoding claim: %w", err)
+	}
+	link, err := UpdateLink(reqCtx, claim.Code, func(l *Link) error {
+		if l.Owner != claim.Owner {
+			return ErrClaimNotFound
+		}
+		l.Owner, l.Folder = owner, ""
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		err = ErrClaimNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A claim left behind cannot be used again, as its link changed owner, and expires anyway.
+	deleteCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+	if err := storeService.redisClient.Del(deleteCtx, claimKey(token)).Err(); err != nil {
+		slog.WarnContext(reqCtx, "Failed to delete used claim", slog.String("short_url", link.Code), slog.Any("error", err))
+	}
+	return link, nil
+}
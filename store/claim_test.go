@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimLink(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	run := time.Now().UnixNano()
+	anonymous, account := fmt.Sprintf("anonymous-%d", run), fmt.Sprintf("account-%d", run)
+
+	folder := &Folder{Owner: anonymous, Name: "Drafts"}
+	require.NoError(t, CreateFolder(context.Background(), folder))
+	claimed := &Link{Code: fmt.Sprintf("claimed-%d", run), Destination: "https://example.com", Owner: anonymous, Folder: folder.ID}
+	require.NoError(t, SaveLink(context.Background(), claimed))
+	other := &Link{Code: fmt.Sprintf("unclaimed-%d", run), Destination: "https://example.com", Owner: anonymous}
+	require.NoError(t, SaveLink(context.Background(), other))
+	token, err := CreateClaim(context.Background(), claimed, time.Minute)
+	require.NoError(t, err)
+
+	// Only the link of the token moves, and the token works once.
+	_, err = ClaimLink(context.Background(), "unknown", account)
+	assert.ErrorIs(t, err, ErrClaimNotFound)
+	link, err := ClaimLink(context.Background(), token, account)
+	require.NoError(t, err)
+	assert.Equal(t, account, link.Owner)
+	assert.Empty(t, link.Folder)
+	_, err = ClaimLink(context.Background(), token, "someone-else")
+	assert.ErrorIs(t, err, ErrClaimNotFound)
+	page, err := ListLinks(context.Background(), LinkQuery{Owner: anonymous})
+	require.NoError(t, err)
+	require.Len(t, page.Links, 1)
+	assert.Equal(t, other.Code, page.Links[0].Code)
+
+	// Links that changed owner since the token was given cannot be claimed with it.
+	token, err = CreateClaim(context.Background(), other, time.Minute)
+	require.NoError(t, err)
+	_, err = UpdateLink(context.Background(), other.Code, func(l *Link) error {
+		l.Owner = "moderator"
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = ClaimLink(context.Background(), token, account)
+	assert.ErrorIs(t, err, ErrClaimNotFound)
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/bloom"
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// scanBatchSize is the number of keys requested per SCAN call while rebuilding the code filter.
+const scanBatchSize = 1000
+
+var (
+	// codeFilter is the Bloom filter of existing short codes, consulted before any lookup so
+	// scans of random codes are rejected without reaching the cache or Redis.
+	// It is nil when the filter is disabled.
+	codeFilter *bloom.Filter
+
+	// codeFilterReady is set once the filter has been rebuilt from the store.
+	// Until then, every code is treated as possibly present.
+	codeFilterReady atomic.Bool
+
+	// codeFilterRebuilding is set while refreshCodeFilter runs, so rebuilds do not pile up.
+	codeFilterRebuilding atomic.Bool
+)
+
+// initCodeFilter creates an empty code filter sized from the configuration,
+// or disables it when the false-positive rate is 0.
+func initCodeFilter(cfg config.Config) {
+	codeFilterReady.Store(false)
+	if cfg.BloomFilterFPRate == 0 {
+		codeFilter = nil
+		return
+	}
+	codeFilter = bloom.New(cfg.BloomFilterCapacity, cfg.BloomFilterFPRate)
+}
+
+// addToCodeFilter records that a short code exists. Codes are never removed: a deleted code
+// only becomes a false positive, which falls through to the store.
+func addToCodeFilter(shortUrl string) {
+	if codeFilter == nil {
+		return
+	}
+	codeFilter.Add(shortUrl)
+	metrics.SetBloomFilterFalsePositiveRate(codeFilter.EstimatedFalsePositiveRate())
+}
+
+// mayExist reports whether a short code may exist in the store.
+// It only returns false for codes that do not exist, or were created on another replica whose invalidation
+// message was lost; those are picked up by the next rebuild of the filter.
+func mayExist(shortUrl string) bool {
+	if codeFilter == nil || !codeFilterReady.Load() {
+		return true
+	}
+	present := codeFilter.Test(shortUrl)
+	metrics.RecordBloomFilterCheck(present)
+	return present
+}
+
+// rebuildCodeFilter adds every short code found in Redis to the code filter, then starts using it.
+// Codes created while the rebuild is running are added to the same filter, so none is missed.
+// In Cluster mode every master is scanned.
+func rebuildCodeFilter(ctx context.Context, redisClient redis.UniversalClient) error {
+	if codeFilter == nil {
+		return nil
+	}
+
+	start := time.Now()
+	var codes atomic.Int64
+	scan := func(scanCtx context.Context, client *redis.Client) error {
+		return scanCodes(scanCtx, client, func(shortUrl string) {
+			codeFilter.Add(shortUrl)
+			codes.Add(1)
+		})
+	}
+
+	var err error
+	if cluster, ok := redisClient.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, scan)
+	} else if client, ok := redisClient.(*redis.Client); ok {
+		err = scan(ctx, client)
+	}
+	metrics.ObserveStore("rebuild_code_filter", start, err)
+	if err != nil {
+		return err
+	}
+
+	codeFilterReady.Store(true)
+	metrics.SetBloomFilterFalsePositiveRate(codeFilter.EstimatedFalsePositiveRate())
+	slog.Info("Short code filter rebuilt",
+		slog.Int64("codes", codes.Load()),
+		slog.Duration("duration", time.Since(start)),
+		slog.Float64("estimated_false_positive_rate", codeFilter.EstimatedFalsePositiveRate()),
+	)
+	return nil
+}
+
+// refreshCodeFilter rebuilds the code filter from the store, unless a rebuild is already running,
+// adding the codes created on other replicas whose invalidation messages were lost.
+// Failures are logged; the filter keeps answering from the codes it has.
+func refreshCodeFilter(ctx context.Context, redisClient redis.UniversalClient, reason string) {
+	if codeFilter == nil || !codeFilterRebuilding.CompareAndSwap(false, true) {
+		return
+	}
+	defer codeFilterRebuilding.Store(false)
+	slog.Debug("Rebuilding the short code filter", slog.String("reason", reason))
+	if err := rebuildCodeFilter(ctx, redisClient); err != nil && ctx.Err() == nil && !errors.Is(err, redis.ErrClosed) {
+		slog.Error("Failed to rebuild the short code filter", slog.String("reason", reason), slog.Any("error", err))
+	}
+}
+
+// rebuildCodeFilterPeriodically runs refreshCodeFilter at the configured interval until ctx is canceled by Close.
+func rebuildCodeFilterPeriodically(ctx context.Context, redisClient redis.UniversalClient) {
+	interval := config.AppConfig.BloomFilterRebuildInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if storeService.connected.Load() {
+			refreshCodeFilter(ctx, redisClient, "periodic")
+		}
+	}
+}
+
+// scanCodes calls fn with every short code stored on one Redis server.
+// The additional keys of a link, which start with a hash tag, are skipped.
+func scanCodes(scanCtx context.Context, client *redis.Client, fn func(shortUrl string)) error {
+	prefix := config.AppConfig.RedisKeyPrefix
+	iter := client.Scan(scanCtx, 0, escapeGlob(prefix)+"*", scanBatchSize).Iterator()
+	for iter.Next(scanCtx) {
+		k := iter.Val()
+		if strings.HasPrefix(k, "{") {
+			continue
+		}
+		fn(strings.TrimPrefix(k, prefix))
+	}
+	return iter.Err()
+}
+
+// escapeGlob escapes the characters that have a special meaning in Redis glob patterns.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
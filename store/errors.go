@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Errors returned by store lookups. Callers test for them with errors.Is.
+var (
+	// ErrNotFound is returned when no link exists for a short code.
+	ErrNotFound = errors.New("link not found")
+
+	// ErrExpired is returned when a link exists but its expiry has passed.
+	ErrExpired = errors.New("link expired")
+
+	// ErrDeleted is returned when a link exists but is in the trash.
+	ErrDeleted = errors.New("link deleted")
+
+	// ErrCodeUnavailable is returned when a short code cannot be taken because it belongs to a link
+	// in the trash or disabled by moderators, or is quarantined after a link in the trash was purged.
+	ErrCodeUnavailable = errors.New("short code unavailable")
+
+	// ErrCodeTaken is returned when a short code belongs to a live link, which creating a link never replaces.
+	ErrCodeTaken = errors.New("short code taken")
+
+	// ErrUnavailable wraps failures of the backend itself, such as connection errors and timeouts,
+	// as opposed to the link not existing. Such failures are worth retrying later.
+	ErrUnavailable = errors.New("store unavailable")
+)
+
+// storeError maps an error returned by Redis to the store's typed errors.
+// A missing key becomes ErrNotFound; any other failure is wrapped in ErrUnavailable.
+func storeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, redis.Nil):
+		return ErrNotFound
+	case errors.Is(err, context.Canceled):
+		// The caller went away; this says nothing about the backend.
+		return err
+	default:
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+}
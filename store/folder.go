@@ -0,0 +1,205 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrFolderNotFound is returned when an owner has no folder with the requested ID.
+var ErrFolderNotFound = errors.New("folder not found")
+
+// Folder groups an owner's links, for example the links of one campaign.
+type Folder struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ownerFoldersKey returns the key of the hash holding an owner's folders by ID.
+// It shares the hash tag of the owner's link index, so both live on the same slot.
+func ownerFoldersKey(owner string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "owner:" + owner + "}:folders"
+}
+
+// CreateFolder stores a new folder for its owner, assigning its ID and timestamps.
+func CreateFolder(reqCtx context.Context, folder *Folder) error {
+	now := time.Now().UTC()
+	folder.ID = uuid.NewString()
+	folder.CreatedAt, folder.UpdatedAt = now, now
+	return writeFolder(reqCtx, "create_folder", folder)
+}
+
+// GetFolder returns an owner's folder, or ErrFolderNotFound if the owner has no folder with that ID.
+func GetFolder(reqCtx context.Context, owner, id string) (*Folder, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	value, err := storeService.redisClient.HGet(reqCtx, ownerFoldersKey(owner), id).Result()
+	if errors.Is(err, redis.Nil) {
+		metrics.ObserveStore("get_folder", start, nil)
+		return nil, ErrFolderNotFound
+	}
+	metrics.ObserveStore("get_folder", start, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return decodeFolder(value)
+}
+
+// ListFolders returns an owner's folders sorted by name.
+func ListFolders(reqCtx context.Context, owner string) ([]*Folder, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	values, err := storeService.redisClient.HVals(reqCtx, ownerFoldersKey(owner)).Result()
+	metrics.ObserveStore("list_folders", start, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	folders := make([]*Folder, 0, len(values))
+	for _, value := range values {
+		folder, err := decodeFolder(value)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	slices.SortFunc(folders, func(a, b *Folder) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return folders, nil
+}
+
+// UpdateFolder applies a change to an owner's folder and saves it, setting its update time.
+// If the owner's folders change concurrently, the folder is read again and the change applied again,
+// so concurrent updates are not lost and a deleted folder is not brought back.
+// Returns the updated folder, or ErrFolderNotFound if the owner has no folder with that ID.
+func UpdateFolder(reqCtx context.Context, owner, id string, change func(*Folder)) (*Folder, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	foldersKey := ownerFoldersKey(owner)
+	var folder *Folder
+	update := func(tx *redis.Tx) error {
+		value, err := tx.HGet(reqCtx, foldersKey, id).Result()
+		if errors.Is(err, redis.Nil) {
+			return ErrFolderNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if folder, err = decodeFolder(value); err != nil {
+			return err
+		}
+		change(folder)
+		folder.ID, folder.Owner = id, owner
+		folder.UpdatedAt = time.Now().UTC()
+		encoded, err := json.Marshal(folder)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(reqCtx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(reqCtx, foldersKey, id, encoded)
+			return nil
+		})
+		return err
+	}
+
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if err = storeService.redisClient.Watch(reqCtx, update, foldersKey); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	metrics.ObserveStore("update_folder", start, ignore(err, ErrFolderNotFound))
+	if errors.Is(err, ErrFolderNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return folder, nil
+}
+
+// DeleteFolder deletes an owner's folder and takes its links out of it; the links themselves are kept.
+// The owner's links are read in batches, each under its own store timeout.
+// Returns ErrFolderNotFound if the owner has no folder with that ID.
+func DeleteFolder(reqCtx context.Context, owner, id string) error {
+	reqCtx, span := tracing.Start(reqCtx, "store.DeleteFolder")
+	deleteCtx, cancel := withTimeout(reqCtx)
+	start := time.Now()
+	deleted, err := storeService.redisClient.HDel(deleteCtx, ownerFoldersKey(owner), id).Result()
+	metrics.ObserveStore("delete_folder", start, err)
+	cancel()
+	tracing.End(span, err)
+	if err != nil {
+		return storeError(err)
+	}
+	if deleted == 0 {
+		return ErrFolderNotFound
+	}
+
+	var updateErr error
+	err = ownerIndexScan(LinkQuery{Owner: owner}).each(reqCtx, func(link *Link, _ cursor) bool {
+		if link.Folder != id {
+			return true
+		}
+		_, updateErr = UpdateLink(reqCtx, link.Code, func(l *Link) error {
+			if l.Folder != id {
+				return errUnchanged
+			}
+			l.Folder = ""
+			return nil
+		})
+		if errors.Is(updateErr, errUnchanged) || errors.Is(updateErr, ErrNotFound) {
+			updateErr = nil
+		}
+		return updateErr == nil
+	})
+	if err != nil {
+		return storeError(err)
+	}
+	return updateErr
+}
+
+// writeFolder stores a folder in its owner's folder hash.
+func writeFolder(reqCtx context.Context, operation string, folder *Folder) error {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	value, err := json.Marshal(folder)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = storeService.redisClient.HSet(reqCtx, ownerFoldersKey(folder.Owner), folder.ID, value).Err()
+	metrics.ObserveStore(operation, start, err)
+	return storeError(err)
+}
+
+// decodeFolder parses a folder stored as JSON.
+func decodeFolder(value string) (*Folder, error) {
+	var folder Folder
+	if err := json.Unmarshal([]byte(value), &folder); err != nil {
+		return nil, fmt.Errorf("decoding folder: %w", err)
+	}
+	return &folder, nil
+}
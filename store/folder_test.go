@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFolders(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	owner := fmt.Sprintf("folder-owner-%d", time.Now().UnixNano())
+
+	campaign := &Folder{Owner: owner, Name: "Spring campaign"}
+	assert.NoError(t, CreateFolder(context.Background(), campaign))
+	assert.NotEmpty(t, campaign.ID)
+	archive := &Folder{Owner: owner, Name: "Archive"}
+	assert.NoError(t, CreateFolder(context.Background(), archive))
+
+	folders, err := ListFolders(context.Background(), owner)
+	assert.NoError(t, err)
+	assert.Len(t, folders, 2)
+	assert.Equal(t, "Archive", folders[0].Name)
+
+	updated, err := UpdateFolder(context.Background(), owner, campaign.ID, func(f *Folder) { f.Name = "Summer campaign" })
+	assert.NoError(t, err)
+	assert.Equal(t, "Summer campaign", updated.Name)
+	_, err = GetFolder(context.Background(), "someone-else", campaign.ID)
+	assert.ErrorIs(t, err, ErrFolderNotFound)
+
+	// Deleting a folder keeps its links, outside of any folder.
+	deleteLinks(t, "foldered-link")
+	assert.NoError(t, SaveLink(context.Background(), &Link{Code: "foldered-link", Destination: "https://example.com", Owner: owner, Folder: campaign.ID}))
+	assert.NoError(t, DeleteFolder(context.Background(), owner, campaign.ID))
+	link, err := GetLink(context.Background(), "foldered-link")
+	assert.NoError(t, err)
+	assert.Empty(t, link.Folder)
+	assert.ErrorIs(t, DeleteFolder(context.Background(), owner, campaign.ID), ErrFolderNotFound)
+
+	// Folders deleted while being updated stay deleted.
+	_, err = UpdateFolder(context.Background(), owner, archive.ID, func(f *Folder) {
+		assert.NoError(t, DeleteFolder(context.Background(), owner, archive.ID))
+		f.Name = "Resurrected"
+	})
+	assert.ErrorIs(t, err, ErrFolderNotFound)
+	_, err = GetFolder(context.Background(), owner, archive.ID)
+	assert.ErrorIs(t, err, ErrFolderNotFound)
+}
+
+func TestUpdateLink(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+
+	deleteLinks(t, "updated-link")
+	saved := &Link{Code: "updated-link", Destination: "https://example.com", Owner: "user-id", Tags: []string{"a", "b"}}
+	assert.NoError(t, SaveLink(context.Background(), saved))
+
+	link, err := UpdateLink(context.Background(), "updated-link", func(l *Link) error {
+		l.AddTags("b", "c")
+		l.RemoveTags("a")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, link.Tags)
+	assert.True(t, link.UpdatedAt.After(saved.UpdatedAt))
+	assert.Equal(t, saved.CreatedAt.UnixNano(), link.CreatedAt.UnixNano())
+
+	// Errors of the change abort the update.
+	_, err = UpdateLink(context.Background(), "updated-link", func(l *Link) error { return ErrNotFound })
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = UpdateLink(context.Background(), "missing-link", func(l *Link) error { return nil })
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLinkStats(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	owner := fmt.Sprintf("stats-owner-%d", time.Now().UnixNano())
+
+	folder := &Folder{Owner: owner, Name: "Campaign"}
+	assert.NoError(t, CreateFolder(context.Background(), folder))
+	links := []*Link{
+		{Code: owner + "-a", Destination: "https://example.com/a", Owner: owner, Folder: folder.ID, Tags: []string{"x", "y"}},
+		{Code: owner + "-b", Destination: "https://example.com/b", Owner: owner, Folder: folder.ID, Tags: []string{"x"}},
+		{Code: owner + "-c", Destination: "https://example.com/c", Owner: owner},
+	}
+	for _, link := range links {
+		assert.NoError(t, SaveLink(context.Background(), link))
+	}
+	for _, link := range []*Link{links[0], links[0], links[2]} {
+		RecordClick(context.Background(), link)
+	}
+	// Clicks are recorded in the background.
+	assert.Eventually(t, func() bool {
+		clicks, _ := storeService.redisClient.Get(context.Background(), subKey(links[0].Code, clicksSuffix)).Int()
+		return clicks == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		clicks, _ := storeService.redisClient.Get(context.Background(), subKey(links[2].Code, clicksSuffix)).Int()
+		return clicks == 1
+	}, time.Second, 10*time.Millisecond)
+
+	stats, err := LinkStats(context.Background(), owner, GroupByFolder)
+	assert.NoError(t, err)
+	assert.Equal(t, []GroupStats{
+		{Key: folder.ID, Name: "Campaign", Links: 2, Clicks: 2},
+		{Key: "", Links: 1, Clicks: 1},
+	}, stats)
+
+	stats, err = LinkStats(context.Background(), owner, GroupByTag)
+	assert.NoError(t, err)
+	assert.Equal(t, []GroupStats{
+		{Key: "x", Links: 2, Clicks: 2},
+		{Key: "y", Links: 1, Clicks: 2},
+	}, stats)
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrLoginStateNotFound is returned for single sign-on callbacks whose login is unknown, expired or already completed.
+var ErrLoginStateNotFound = errors.New("login state not found")
+
+// LoginState is what is remembered about a single sign-on login between the redirect to the identity
+// provider and the callback.
+type LoginState struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`            // PKCE code verifier.
+	ReturnTo string `json:"return_to,omitempty"` // Local path to redirect to once logged in.
+}
+
+// identityKey returns the key mapping an identity of an external provider to the ID of the user it logs in as.
+func identityKey(issuer, subject string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "identity:" + tokenHash(issuer+"\x00"+subject) + "}:user"
+}
+
+// loginStateKey returns the key of the state of a single sign-on login.
+func loginStateKey(state string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "login:" + tokenHash(state) + "}:state"
+}
+
+// mappedRolesKey returns the key of the hash of the workspace roles granted to a user by the group mappings of
+// the identity provider, by workspace ID. Roles granted by hand are not in it, so mappings never revoke them.
+func mappedRolesKey(userID string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "user:" + userID + "}:mapped-roles"
+}
+
+// MappedRoles returns the workspace roles granted to a user by group mappings, by workspace ID.
+func MappedRoles(reqCtx context.Context, userID string) (map[string]Role, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	values, err := storeService.redisClient.HGetAll(reqCtx, mappedRolesKey(userID)).Result()
+	metrics.ObserveStore("mapped_roles", start, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	roles := make(map[string]Role, len(values))
+	for workspaceID, role := range values {
+		roles[workspaceID] = Role(role)
+	}
+	return roles, nil
+}
+
+// SetMappedRole records the role a group mapping granted a user in a workspace, or forgets it if role is empty.
+func SetMappedRole(reqCtx context.Context, userID, workspaceID string, role Role) error {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	var err error
+	if role == "" {
+		err = storeService.redisClient.HDel(reqCtx, mappedRolesKey(userID), workspaceID).Err()
+	} else {
+		err = storeService.redisClient.HSet(reqCtx, mappedRolesKey(userID), workspaceID, string(role)).Err()
+	}
+	metrics.ObserveStore("set_mapped_role", start, err)
+	return storeError(err)
+}
+
+// GetIdentityUser returns the user an external identity is linked to, or ErrUserNotFound.
+func GetIdentityUser(reqCtx context.Context, issuer, subject string) (*User, error) {
+	lookupCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	id, err := storeService.redisClient.Get(lookupCtx, identityKey(issuer, subject)).Result()
+	metrics.ObserveStore("get_identity_user", start, ignore(err, redis.Nil))
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return GetUser(reqCtx, id)
+}
+
+// LinkIdentity links an external identity to a user, so it logs in as that user from then on.
+// An identity already linked keeps its user.
+func LinkIdentity(reqCtx context.Context, issuer, subject, userID string) error {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	err := storeService.redisClient.SetNX(reqCtx, identityKey(issuer, subject), userID, 0).Err()
+	metrics.ObserveStore("link_identity", start, err)
+	return storeError(err)
+}
+
+// SaveLoginState remembers the state of a single sign-on login for the given duration.
+func SaveLoginState(reqCtx context.Context, state string, login *LoginState, ttl time.Duration) error {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	value, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = storeService.redisClient.Set(reqCtx, loginStateKey(state), value, ttl).Err()
+	metrics.ObserveStore("save_login_state", start, err)
+	return storeError(err)
+}
+
+// TakeLoginState returns and forgets the state of a single sign-on login, so each login completes once.
+// Returns ErrLoginStateNotFound if there is no such login.
+func TakeLoginState(reqCtx context.Context, state string) (*LoginState, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	value, err := storeService.redisClient.GetDel(reqCtx, loginStateKey(state)).Result()
+	metrics.ObserveStore("take_login_state", start, ignore(err, redis.Nil))
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrLoginStateNotFound
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var login LoginState
+	if err := json.Unmarshal([]byte(value), &login); err != nil {
+		return nil, fmt.Errorf("decoding login state: %w", err)
+	}
+	return &login, nil
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrInvitationNotFound is returned for invitation tokens that are unknown, expired or already used.
+var ErrInvitationNotFound = errors.New("invitation not found")
+
+// Invitation grants a role in a workspace to whoever accepts it, once.
+type Invitation struct {
+	WorkspaceID string    `json:"workspace_id"`
+	Role        Role      `json:"role"`
+	InvitedBy   string    `json:"invited_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// invitationKey returns the key of an invitation. Like sessions, invitations are stored under a hash of their token.
+func invitationKey(token string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "invitation:" + tokenHash(token) + "}:invitation"
+}
+
+// CreateInvitation stores an invitation that can be accepted for the given duration, setting its timestamps,
+// and returns its token.
+func CreateInvitation(reqCtx context.Context, invitation *Invitation, ttl time.Duration) (string, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	invitation.CreatedAt, invitation.ExpiresAt = now, now.Add(ttl)
+	value, err := json.Marshal(invitation)
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	err = storeService.redisClient.Set(reqCtx, invitationKey(token), value, ttl).Err()
+	metrics.ObserveStore("create_invitation", start, err)
+	if err != nil {
+		return "", storeError(err)
+	}
+	return token, nil
+}
+
+// AcceptInvitation uses up an invitation and makes the user a member of its workspace.
+// A user who already is a member keeps their role if it is higher than the invitation's.
+// Returns the workspace and the user's role in it, or ErrInvitationNotFound.
+func AcceptInvitation(reqCtx context.Context, token, userID string) (*Membership, error) {
+	getCtx, cancel := withTimeout(reqCtx)
+	start := time.Now()
+	value, err := storeService.redisClient.GetDel(getCtx, invitationKey(token)).Result()
+	metrics.ObserveStore("accept_invitation", start, ignore(err, redis.Nil))
+	cancel()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var invitation Invitation
+	if err := json.Unmarshal([]byte(value), &invitation); err != nil {
+		return nil, fmt.Errorf("decoding invitation: %w", err)
+	}
+	workspace, err := GetWorkspace(reqCtx, invitation.WorkspaceID)
+	if errors.Is(err, ErrWorkspaceNotFound) {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := ChangeMember(reqCtx, invitation.WorkspaceID, userID, func(current Role) (Role, error) {
+		return MaxRole(current, invitation.Role), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Membership{Workspace: workspace, Role: role}, nil
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
+
+// expiredLinkRetention is how long an expired link is kept in Redis, so lookups report it as
+// expired rather than unknown before it is removed for good.
+const expiredLinkRetention = 24 * time.Hour
+
+// Link is a short link and its metadata, as stored for each short code.
+type Link struct {
+	Code         string     `json:"code"`
+	Destination  string     `json:"destination"`
+	Owner        string     `json:"owner,omitempty"`      // User, IP-based UUID or workspace owning the link.
+	CreatedBy    string     `json:"created_by,omitempty"` // ID of the user who created the link, if logged in.
+	Title        string     `json:"title,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Folder       string     `json:"folder,omitempty"` // ID of the owner's folder holding the link.
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`    // Nil for links that never expire.
+	RedirectCode int        `json:"redirect_code,omitempty"` // 0 uses the configured default_redirect_code.
+	Flags        LinkFlags  `json:"flags"`
+	Version      int        `json:"version,omitempty"`    // Number of the link's latest version, counting from 1.
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"` // Set while the link is in the trash.
+}
+
+// LinkFlags are the switches that change how a link behaves.
+type LinkFlags struct {
+	Disabled       bool   `json:"disabled,omitempty"`        // The link does not redirect anymore.
+	DisabledReason string `json:"disabled_reason,omitempty"` // Why a moderator disabled the link, shown instead of redirecting.
+	OwnerBanned    bool   `json:"owner_banned,omitempty"`    // The link was disabled by the ban of its owner, and is enabled again when it is lifted.
+}
+
+// Expired reports whether the link's expiry has passed at the given time.
+func (l *Link) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// AddTags adds tags the link does not have yet, keeping their order.
+func (l *Link) AddTags(tags ...string) {
+	for _, tag := range tags {
+		if !slices.Contains(l.Tags, tag) {
+			l.Tags = append(l.Tags, tag)
+		}
+	}
+}
+
+// RemoveTags removes tags from the link.
+func (l *Link) RemoveTags(tags ...string) {
+	l.Tags = slices.DeleteFunc(l.Tags, func(tag string) bool { return slices.Contains(tags, tag) })
+	if len(l.Tags) == 0 {
+		l.Tags = nil
+	}
+}
+
+// clone returns a copy of the link that shares no memory with it,
+// so links handed out from the cache can be modified by callers.
+func (l *Link) clone() *Link {
+	c := *l
+	c.Tags = slices.Clone(l.Tags)
+	if l.ExpiresAt != nil {
+		expiresAt := *l.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	if l.DeletedAt != nil {
+		deletedAt := *l.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
+
+// ttl returns the Redis expiration of the link's key: the time until its expiry plus the retention
+// of expired links, or 0 for links that never expire. Links in the trash do not expire, so their code
+// is only freed by the purge job, which quarantines it.
+func (l *Link) ttl(now time.Time) time.Duration {
+	if l.ExpiresAt == nil || l.DeletedAt != nil {
+		return 0
+	}
+	return max(l.ExpiresAt.Sub(now), 0) + expiredLinkRetention
+}
+
+// encodeLink serializes a link as the JSON document stored under its short code.
+func encodeLink(l *Link) (string, error) {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeLink parses the value stored under a short code.
+// Links created before metadata was stored are plain destination URLs; they are returned
+// as links with only their code and destination set.
+func decodeLink(code, value string) (*Link, error) {
+	if !strings.HasPrefix(value, "{") {
+		return &Link{Code: code, Destination: value}, nil
+	}
+	var l Link
+	if err := json.Unmarshal([]byte(value), &l); err != nil {
+		return nil, err
+	}
+	l.Code = code
+	return &l, nil
+}
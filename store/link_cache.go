@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/drunkleen/go-url-shortner/cache"
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// invalidationChannel is the Redis pub/sub channel, under the key prefix, on which replicas announce
+// the short codes whose links changed, so every replica drops them from its in-process cache.
+const invalidationChannel = "link-invalidations"
+
+// cachedLink is an entry of the in-process link cache.
+// Short codes that do not exist are cached too, with a nil link, so repeated lookups of
+// unknown codes do not reach Redis.
+type cachedLink struct {
+	link *Link
+}
+
+var (
+	// linkCache is the in-process cache kept in front of Redis for the redirect path.
+	// It is disabled until InitializeStoreService sizes it from the configuration.
+	linkCache = cache.NewLRU[string, cachedLink](0)
+
+	// lookups collapses concurrent Redis lookups of the same short code into a single one.
+	lookups singleflight.Group
+)
+
+// initLinkCache creates the in-process link cache with the configured size.
+func initLinkCache(cfg config.Config) {
+	linkCache = cache.NewLRU[string, cachedLink](cfg.LinkCacheSize)
+}
+
+// cacheLink remembers the result of a Redis lookup: the link for a known code, or a miss for an
+// unknown one. Misses are kept for the shorter negative TTL, so a code created on another replica
+// becomes visible quickly even if its invalidation message was lost, once the code filter has it too.
+func cacheLink(shortUrl string, link cachedLink) {
+	if link.link != nil {
+		linkCache.Set(shortUrl, link, config.AppConfig.LinkCacheTTL)
+	} else {
+		linkCache.Set(shortUrl, link, config.AppConfig.LinkCacheNegativeTTL)
+	}
+}
+
+// InvalidateLink drops a short code from the in-process cache of this replica and asks every other
+// replica to do the same. It must be called whenever a link is created, updated or deleted.
+// A failure to notify the other replicas is returned, but their entries still expire after the cache TTL.
+func InvalidateLink(reqCtx context.Context, shortUrl string) error {
+	linkCache.Remove(shortUrl)
+	if storeService.redisClient == nil {
+		return nil
+	}
+	return storeService.redisClient.Publish(reqCtx, invalidationChannelName(), shortUrl).Err()
+}
+
+// subscribeInvalidations listens for invalidation messages from other replicas and drops the announced
+// short codes from the in-process cache. Announced codes are also added to the code filter, since they
+// may have just been created on another replica. The subscription reconnects on its own when Redis is
+// unavailable, and ends when it is closed by Close. Messages published while it was disconnected are lost,
+// so the code filter is rebuilt on every reconnection, and cached links expire after their TTL.
+func subscribeInvalidations(ctx context.Context, redisClient redis.UniversalClient) *redis.PubSub {
+	pubsub := redisClient.Subscribe(ctx, invalidationChannelName())
+	go func() {
+		subscribed := false
+		for message := range pubsub.ChannelWithSubscriptions() {
+			switch message := message.(type) {
+			case *redis.Subscription:
+				// The first subscription precedes the initial rebuild; later ones are reconnections.
+				if message.Kind == "subscribe" && subscribed && storeService.connected.Load() {
+					go refreshCodeFilter(ctx, redisClient, "resubscribed")
+				}
+				subscribed = true
+			case *redis.Message:
+				linkCache.Remove(message.Payload)
+				addToCodeFilter(message.Payload)
+			}
+		}
+		slog.Debug("Link cache invalidation subscription closed")
+	}()
+	return pubsub
+}
+
+// invalidationChannelName returns the invalidation channel under the configured key prefix,
+// so deployments sharing one Redis do not invalidate each other's caches.
+func invalidationChannelName() string {
+	return config.AppConfig.RedisKeyPrefix + invalidationChannel
+}
+
+// lookupCachedLink returns the cached result for a short code, recording the cache lookup in the metrics.
+func lookupCachedLink(shortUrl string) (cachedLink, bool) {
+	link, ok := linkCache.Get(shortUrl)
+	switch {
+	case !ok:
+		metrics.RecordCacheLookup("miss")
+	case link.link != nil:
+		metrics.RecordCacheLookup("hit")
+	default:
+		metrics.RecordCacheLookup("negative_hit")
+	}
+	return link, ok
+}
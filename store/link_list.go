@@ -0,0 +1,428 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/redis/go-redis/v9"
+)
+
+// Link statuses, used to filter listings.
+const (
+	LinkStatusActive   = "active"   // The link redirects.
+	LinkStatusExpired  = "expired"  // The link's expiry has passed.
+	LinkStatusDisabled = "disabled" // The link was disabled.
+	LinkStatusDeleted  = "deleted"  // The link is in the trash.
+)
+
+// Fields links can be sorted by.
+const (
+	SortByCreatedAt   = "created_at"
+	SortByUpdatedAt   = "updated_at"
+	SortByExpiresAt   = "expires_at"
+	SortByTitle       = "title"
+	SortByDestination = "destination"
+)
+
+// Page size limits of link listings.
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+// listBatchSize is the number of links fetched from Redis per pipeline while listing.
+const listBatchSize = 100
+
+// ErrInvalidCursor is returned when a listing cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Status returns the status of the link at the given time.
+func (l *Link) Status(now time.Time) string {
+	switch {
+	case l.DeletedAt != nil:
+		return LinkStatusDeleted
+	case l.Flags.Disabled:
+		return LinkStatusDisabled
+	case l.Expired(now):
+		return LinkStatusExpired
+	default:
+		return LinkStatusActive
+	}
+}
+
+// LinkQuery selects, orders and pages the links of an owner.
+// Zero values disable the corresponding filter.
+type LinkQuery struct {
+	Owner         string
+	Tags          []string  // Links must have every tag.
+	Folder        string    // ID of the folder holding the links.
+	Status        string    // One of the LinkStatus constants; links in the trash are only listed with LinkStatusDeleted.
+	CreatedAfter  time.Time // Inclusive.
+	CreatedBefore time.Time // Exclusive.
+	Search        string    // Case-insensitive substring of the destination or title.
+	SortBy        string    // One of the SortBy constants; defaults to SortByCreatedAt.
+	Descending    bool
+	Limit         int    // Defaults to DefaultListLimit, capped at MaxListLimit.
+	Cursor        string // NextCursor of the previous page.
+}
+
+// LinkPage is a page of links. NextCursor is empty on the last page.
+type LinkPage struct {
+	Links      []*Link `json:"links"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// cursor is the position after which the next page starts: the sort key and code of the last link of a page.
+type cursor struct {
+	Key  string `json:"k"`
+	Code string `json:"c"`
+}
+
+// ListLinks returns a page of an owner's links matching the query.
+// The owner index narrows the links to the owner's, and to the creation date range when one is given.
+// Sorted by creation time, the default, pages are read from the index in order, starting at the cursor, so
+// a page only costs the links it holds and those the other filters skip. Sorted by any other field, every
+// link of the owner is loaded to be filtered and sorted in memory, which gets slow for owners with many links.
+// Index entries whose link is gone or now belongs to someone else are removed along the way.
+func ListLinks(reqCtx context.Context, query LinkQuery) (*LinkPage, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.ListLinks")
+	after, err := decodeCursor(query.Cursor)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+
+	now := time.Now()
+	start := time.Now()
+	var page *LinkPage
+	if query.SortBy == "" || query.SortBy == SortByCreatedAt {
+		scan := ownerIndexScan(query)
+		scan.Descending, scan.After = query.Descending, after
+		page, err = seekLinks(reqCtx, scan, query.Limit, func(l *Link) bool { return query.matches(l, now) })
+	} else {
+		var links []*Link
+		if links, err = ownerLinks(reqCtx, query); err == nil {
+			page = pageLinks(links, query, after, now)
+		}
+	}
+	metrics.ObserveStore("list_links", start, ignore(err, ErrInvalidCursor))
+	tracing.End(span, ignore(err, ErrInvalidCursor))
+	switch {
+	case errors.Is(err, ErrInvalidCursor):
+		return nil, err
+	case err != nil:
+		return nil, storeError(err)
+	}
+	return page, nil
+}
+
+// ownerIndexScan returns the scan of the owner index of the query's owner within its creation date range.
+func ownerIndexScan(query LinkQuery) indexScan {
+	scan := indexScan{Key: ownerLinksKey(query.Owner), Belongs: func(l *Link) bool { return l.Owner == query.Owner }}
+	if !query.CreatedAfter.IsZero() {
+		scan.Min = strconv.FormatInt(query.CreatedAfter.UnixMilli(), 10)
+	}
+	if !query.CreatedBefore.IsZero() {
+		scan.Max = "(" + strconv.FormatInt(query.CreatedBefore.UnixMilli(), 10)
+	}
+	return scan
+}
+
+// ownerLinks loads the links indexed under the query's owner within its creation date range.
+func ownerLinks(reqCtx context.Context, query LinkQuery) ([]*Link, error) {
+	var links []*Link
+	err := ownerIndexScan(query).each(reqCtx, func(link *Link, _ cursor) bool {
+		links = append(links, link)
+		return true
+	})
+	return links, err
+}
+
+// seekLinks returns the page of the links of an index scan that pass keep, up to the limit.
+// The cursor of the next page is the index position of the last link of the page.
+func seekLinks(reqCtx context.Context, scan indexScan, limit int, keep func(*Link) bool) (*LinkPage, error) {
+	limit = listLimit(limit)
+	page := &LinkPage{Links: []*Link{}}
+	var last cursor
+	err := scan.each(reqCtx, func(link *Link, position cursor) bool {
+		if !keep(link) {
+			return true
+		}
+		if len(page.Links) == limit {
+			page.NextCursor = encodeCursor(last)
+			return false
+		}
+		page.Links = append(page.Links, link)
+		last = position
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// indexScan walks a sorted set indexing links by creation time, such as an owner index.
+type indexScan struct {
+	Key        string
+	Min, Max   string // Score bounds, in milliseconds, as taken by ZRANGEBYSCORE; empty for no bound.
+	Descending bool
+	After      *cursor          // Position the walk starts after, from a page cursor.
+	Belongs    func(*Link) bool // Whether a link still belongs to the index.
+}
+
+// each calls visit with the links of the index and their positions, oldest first or newest first, until
+// visit returns false. The index is read in batches, each with its own store timeout, so long walks do not
+// run out of time; the next batch starts at the score of the last entry read, past the entries with that score
+// already seen. Entries whose link is gone or does not belong to the index are removed once the walk ends.
+// Returns ErrInvalidCursor for a cursor that is not an index position.
+func (s indexScan) each(reqCtx context.Context, visit func(link *Link, position cursor) bool) error {
+	low, high := cmp.Or(s.Min, "-inf"), cmp.Or(s.Max, "+inf")
+	var afterScore int64
+	if s.After != nil {
+		var err error
+		if afterScore, err = strconv.ParseInt(s.After.Key, 10, 64); err != nil {
+			return ErrInvalidCursor
+		}
+		if s.Descending {
+			high = strconv.FormatInt(afterScore, 10)
+		} else {
+			low = strconv.FormatInt(afterScore, 10)
+		}
+	}
+
+	var stale []any
+	defer func() {
+		// Clean up the index; a failure only leaves entries that are skipped again next time.
+		if len(stale) > 0 {
+			cleanupCtx, cancel := withTimeout(reqCtx)
+			storeService.redisClient.ZRem(cleanupCtx, s.Key, stale...)
+			cancel()
+		}
+	}()
+
+	var lastScore, ties int64
+	for {
+		batchCtx, cancel := withTimeout(reqCtx)
+		bounds := &redis.ZRangeBy{Min: low, Max: high, Offset: ties, Count: listBatchSize}
+		var entries []redis.Z
+		var err error
+		if s.Descending {
+			entries, err = storeService.redisClient.ZRevRangeByScoreWithScores(batchCtx, s.Key, bounds).Result()
+		} else {
+			entries, err = storeService.redisClient.ZRangeByScoreWithScores(batchCtx, s.Key, bounds).Result()
+		}
+		codes := make([]string, len(entries))
+		for i, entry := range entries {
+			codes[i], _ = entry.Member.(string)
+		}
+		var links []*Link
+		if err == nil {
+			links, err = loadLinks(batchCtx, codes)
+		}
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		for i, entry := range entries {
+			score := int64(entry.Score)
+			if score != lastScore {
+				lastScore, ties = score, 0
+			}
+			ties++
+			if s.After != nil && score == afterScore && (s.Descending && codes[i] >= s.After.Code || !s.Descending && codes[i] <= s.After.Code) {
+				continue
+			}
+			if links[i] == nil || !s.Belongs(links[i]) {
+				stale = append(stale, codes[i])
+				continue
+			}
+			if !visit(links[i], cursor{Key: scoreKey(score), Code: codes[i]}) {
+				return nil
+			}
+		}
+		if len(entries) < listBatchSize {
+			return nil
+		}
+		if s.Descending {
+			high = strconv.FormatInt(lastScore, 10)
+		} else {
+			low = strconv.FormatInt(lastScore, 10)
+		}
+	}
+}
+
+// loadLinks loads the links stored under short codes, in the same order, with nil for the codes without one.
+func loadLinks(reqCtx context.Context, codes []string) ([]*Link, error) {
+	cmds := make([]*redis.StringCmd, len(codes))
+	_, err := storeService.redisClient.Pipelined(reqCtx, func(pipe redis.Pipeliner) error {
+		for i, code := range codes {
+			cmds[i] = pipe.Get(reqCtx, key(code))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	links := make([]*Link, len(codes))
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if links[i], err = decodeLink(codes[i], value); err != nil {
+			return nil, fmt.Errorf("decoding link %q: %w", codes[i], err)
+		}
+	}
+	return links, nil
+}
+
+// pageLinks filters and sorts links, then returns the page that follows the cursor.
+func pageLinks(links []*Link, query LinkQuery, after *cursor, now time.Time) *LinkPage {
+	type keyed struct {
+		link *Link
+		key  string
+	}
+	var items []keyed
+	for _, l := range links {
+		if query.matches(l, now) {
+			items = append(items, keyed{link: l, key: sortKey(l, query.SortBy)})
+		}
+	}
+	compare := func(aKey, aCode, bKey, bCode string) int {
+		c := cmp.Or(strings.Compare(aKey, bKey), strings.Compare(aCode, bCode))
+		if query.Descending {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(items, func(a, b keyed) int { return compare(a.key, a.link.Code, b.key, b.link.Code) })
+
+	// Skip the links up to and including the cursor position.
+	if after != nil {
+		first, _ := slices.BinarySearchFunc(items, *after, func(item keyed, c cursor) int {
+			if compare(item.key, item.link.Code, c.Key, c.Code) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		items = items[first:]
+	}
+
+	limit := listLimit(query.Limit)
+	page := &LinkPage{Links: []*Link{}}
+	for _, item := range items[:min(limit, len(items))] {
+		page.Links = append(page.Links, item.link)
+	}
+	if len(items) > limit {
+		last := items[limit-1]
+		page.NextCursor = encodeCursor(cursor{Key: last.key, Code: last.link.Code})
+	}
+	return page
+}
+
+// listLimit returns the page size of a listing: the requested limit, DefaultListLimit by default,
+// capped at MaxListLimit.
+func listLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultListLimit
+	}
+	return min(limit, MaxListLimit)
+}
+
+// matches reports whether a link passes the query's filters at the given time.
+func (q LinkQuery) matches(l *Link, now time.Time) bool {
+	for _, tag := range q.Tags {
+		if !slices.Contains(l.Tags, tag) {
+			return false
+		}
+	}
+	if q.Folder != "" && l.Folder != q.Folder {
+		return false
+	}
+	if q.Status != "" && l.Status(now) != q.Status || q.Status == "" && l.DeletedAt != nil {
+		return false
+	}
+	if !q.CreatedAfter.IsZero() && l.CreatedAt.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !l.CreatedAt.Before(q.CreatedBefore) {
+		return false
+	}
+	if q.Search != "" {
+		search := strings.ToLower(q.Search)
+		if !strings.Contains(strings.ToLower(l.Destination), search) && !strings.Contains(strings.ToLower(l.Title), search) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortKey returns the string links are ordered by for the given sort field.
+// Times are rendered as fixed-width numbers so they order correctly as strings;
+// links that never expire sort after all others.
+func sortKey(l *Link, sortBy string) string {
+	switch sortBy {
+	case SortByUpdatedAt:
+		return timeKey(l.UpdatedAt)
+	case SortByExpiresAt:
+		if l.ExpiresAt == nil {
+			return fmt.Sprintf("%020d", math.MaxInt64)
+		}
+		return timeKey(*l.ExpiresAt)
+	case SortByTitle:
+		return strings.ToLower(l.Title)
+	case SortByDestination:
+		return strings.ToLower(l.Destination)
+	default:
+		// Creation times are indexed in milliseconds, so listings read from the index and sorted in memory agree.
+		return scoreKey(l.CreatedAt.UnixMilli())
+	}
+}
+
+// scoreKey renders an index score, a number of milliseconds, as a fixed-width, lexically ordered number.
+func scoreKey(score int64) string {
+	return fmt.Sprintf("%020d", score)
+}
+
+// timeKey renders a time as a fixed-width, lexically ordered number of nanoseconds.
+func timeKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// encodeCursor renders a cursor as an opaque URL-safe string.
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor returned by encodeCursor. An empty string is the start of the listing.
+func decodeCursor(raw string) (*cursor, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLinks returns five links created one hour apart, the third one tagged and disabled.
+func testLinks(base time.Time) []*Link {
+	var links []*Link
+	for i := 0; i < 5; i++ {
+		links = append(links, &Link{
+			Code:        fmt.Sprintf("code%d", i),
+			Destination: fmt.Sprintf("https://example.com/%d", i),
+			Title:       fmt.Sprintf("Link %d", 4-i),
+			CreatedAt:   base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	links[2].Tags = []string{"docs"}
+	links[2].Flags.Disabled = true
+	return links
+}
+
+// codes returns the codes of a page of links.
+func codes(page *LinkPage) []string {
+	var result []string
+	for _, l := range page.Links {
+		result = append(result, l.Code)
+	}
+	return result
+}
+
+func TestPageLinks(t *testing.T) {
+	base := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	links := testLinks(base)
+
+	// Pages follow each other without gaps or duplicates.
+	page := pageLinks(links, LinkQuery{Limit: 2}, nil, base)
+	assert.Equal(t, []string{"code0", "code1"}, codes(page))
+	after, err := decodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	page = pageLinks(links, LinkQuery{Limit: 2}, after, base)
+	assert.Equal(t, []string{"code2", "code3"}, codes(page))
+	after, _ = decodeCursor(page.NextCursor)
+	page = pageLinks(links, LinkQuery{Limit: 2}, after, base)
+	assert.Equal(t, []string{"code4"}, codes(page))
+	assert.Empty(t, page.NextCursor)
+
+	// Sorting by another field, in descending order.
+	page = pageLinks(links, LinkQuery{SortBy: SortByTitle}, nil, base)
+	assert.Equal(t, []string{"code4", "code3", "code2", "code1", "code0"}, codes(page))
+	page = pageLinks(links, LinkQuery{Descending: true, Limit: 1}, nil, base)
+	assert.Equal(t, []string{"code4"}, codes(page))
+
+	// Filters.
+	page = pageLinks(links, LinkQuery{Tags: []string{"docs"}}, nil, base)
+	assert.Equal(t, []string{"code2"}, codes(page))
+	page = pageLinks(links, LinkQuery{Status: LinkStatusActive}, nil, base)
+	assert.Equal(t, []string{"code0", "code1", "code3", "code4"}, codes(page))
+	page = pageLinks(links, LinkQuery{CreatedAfter: base.Add(time.Hour), CreatedBefore: base.Add(3 * time.Hour)}, nil, base)
+	assert.Equal(t, []string{"code1", "code2"}, codes(page))
+	page = pageLinks(links, LinkQuery{Search: "LINK 3"}, nil, base)
+	assert.Equal(t, []string{"code1"}, codes(page))
+	page = pageLinks(links, LinkQuery{Search: "example.com/4"}, nil, base)
+	assert.Equal(t, []string{"code4"}, codes(page))
+}
+
+func TestDecodeCursor(t *testing.T) {
+	_, err := decodeCursor("not a cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	c, err := decodeCursor(encodeCursor(cursor{Key: "k", Code: "c"}))
+	assert.NoError(t, err)
+	assert.Equal(t, &cursor{Key: "k", Code: "c"}, c)
+}
+
+func TestListLinks(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	owner := fmt.Sprintf("owner-%d", time.Now().UnixNano())
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	deleteLinks(t, "code0", "code1", "code2", "code3", "code4")
+	for _, link := range testLinks(base) {
+		link.Owner = owner
+		assert.NoError(t, SaveLink(context.Background(), link))
+	}
+
+	page, err := ListLinks(context.Background(), LinkQuery{Owner: owner, Descending: true, Limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"code4", "code3", "code2"}, codes(page))
+	page, err = ListLinks(context.Background(), LinkQuery{Owner: owner, Descending: true, Cursor: page.NextCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"code1", "code0"}, codes(page))
+
+	// Links given to another owner disappear from the listing.
+	_, err = UpdateLink(context.Background(), "code0", func(l *Link) error {
+		l.Owner = "someone-else"
+		return nil
+	})
+	assert.NoError(t, err)
+	page, err = ListLinks(context.Background(), LinkQuery{Owner: owner, CreatedBefore: base.Add(2 * time.Hour)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"code1"}, codes(page))
+
+	_, err = ListLinks(context.Background(), LinkQuery{Owner: owner, Cursor: "garbage"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestListLinksPagesThroughIndex(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	run := time.Now().UnixNano()
+	owner := fmt.Sprintf("owner-%d", run)
+
+	// More links than a batch, most of them created in the same millisecond.
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	var want []string
+	for i := 0; i < 2*listBatchSize+50; i++ {
+		link := &Link{Code: fmt.Sprintf("seek-%d-%03d", run, i), Destination: "https://example.com", Owner: owner,
+			CreatedAt: base.Add(time.Duration(i/100) * time.Millisecond)}
+		assert.NoError(t, SaveLink(context.Background(), link))
+		want = append(want, link.Code)
+	}
+
+	list := func(descending bool) []string {
+		var got []string
+		query := LinkQuery{Owner: owner, Descending: descending, Limit: MaxListLimit - 1}
+		for {
+			page, err := ListLinks(context.Background(), query)
+			assert.NoError(t, err)
+			got = append(got, codes(page)...)
+			if page.NextCursor == "" {
+				return got
+			}
+			query.Cursor = page.NextCursor
+		}
+	}
+	assert.Equal(t, want, list(false))
+	slices.Reverse(want)
+	assert.Equal(t, want, list(true))
+}
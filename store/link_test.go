@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeLink(t *testing.T) {
+	// Legacy values are plain destination URLs.
+	link, err := decodeLink("abc12345", "https://example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, &Link{Code: "abc12345", Destination: "https://example.com"}, link)
+
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored := &Link{
+		Code:         "abc12345",
+		Destination:  "https://example.com",
+		Owner:        "user-id",
+		Title:        "Example",
+		Tags:         []string{"docs"},
+		CreatedAt:    time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:    time.Date(2029, 6, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresAt:    &expiresAt,
+		RedirectCode: 302,
+		Flags:        LinkFlags{Disabled: true},
+	}
+	value, err := encodeLink(stored)
+	assert.NoError(t, err)
+	link, err = decodeLink("abc12345", value)
+	assert.NoError(t, err)
+	assert.Equal(t, stored, link)
+
+	_, err = decodeLink("abc12345", "{not json")
+	assert.Error(t, err)
+}
+
+func TestLinkExpiry(t *testing.T) {
+	now := time.Now()
+	link := &Link{}
+	assert.False(t, link.Expired(now))
+	assert.Zero(t, link.ttl(now))
+
+	expiresAt := now.Add(time.Hour)
+	link.ExpiresAt = &expiresAt
+	assert.False(t, link.Expired(now))
+	assert.True(t, link.Expired(expiresAt))
+	assert.Equal(t, time.Hour+expiredLinkRetention, link.ttl(now))
+}
+
+func TestGetLink(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+
+	deleteLinks(t, "link-metadata", "link-expired")
+
+	// Links are stored with their metadata and returned as saved.
+	saved := &Link{Code: "link-metadata", Destination: "https://example.com", Owner: "user-id", Title: "Example", RedirectCode: 302}
+	assert.NoError(t, SaveLink(context.Background(), saved))
+	assert.False(t, saved.CreatedAt.IsZero())
+	link, err := GetLink(context.Background(), "link-metadata")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", link.Owner)
+	assert.Equal(t, "Example", link.Title)
+	assert.Equal(t, 302, link.RedirectCode)
+
+	// Legacy plain-string values are still readable.
+	assert.NoError(t, storeService.redisClient.Set(context.Background(), key("link-legacy"), "https://example.com/legacy", 0).Err())
+	link, err = GetLink(context.Background(), "link-legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/legacy", link.Destination)
+
+	// Expired links are reported as such until they are removed.
+	expiresAt := time.Now().Add(-time.Minute)
+	assert.NoError(t, SaveLink(context.Background(), &Link{Code: "link-expired", Destination: "https://example.com", ExpiresAt: &expiresAt}))
+	_, err = GetLink(context.Background(), "link-expired")
+	assert.ErrorIs(t, err, ErrExpired)
+}
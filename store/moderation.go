@@ -0,0 +1,484 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotBanned is returned for owners that are not banned.
+var ErrNotBanned = errors.New("owner not banned")
+
+// errUnchanged aborts an update of a link that needs no change.
+var errUnchanged = errors.New("link unchanged")
+
+// errValueChanged is returned by compareAndDelete for keys holding another value than expected.
+var errValueChanged = errors.New("value changed")
+
+const (
+	// ownerSyncLease is how long a replica holds the lease on an owner whose links it brings in line with a
+	// ban change. It is renewed as links are updated, and lets another replica take over if the replica stops.
+	ownerSyncLease = time.Minute
+
+	// ownerSyncRetryInterval is how often each replica resumes the owner syncs left undone.
+	ownerSyncRetryInterval = time.Minute
+)
+
+// Ban keeps an owner from creating links. Banning an owner also disables its links.
+type Ban struct {
+	Owner    string    `json:"owner"`
+	Reason   string    `json:"reason"`
+	BannedAt time.Time `json:"banned_at"`
+
+	// LinksPending is set while the links of the owner are being brought in line with a ban change.
+	LinksPending bool `json:"links_pending"`
+}
+
+// LinkSearch selects links across owners for moderation. Either Domain or Owner is required.
+type LinkSearch struct {
+	Domain string // Domain of the destination; links to its subdomains match too.
+	Owner  string
+	Status string // One of the LinkStatus constants; links in the trash are only listed with LinkStatusDeleted.
+	Limit  int    // Defaults to DefaultListLimit, capped at MaxListLimit.
+	Cursor string // NextCursor of the previous page.
+}
+
+// domainLinksKey returns the key of the sorted set indexing by creation time the links whose destination
+// is on a domain or one of its subdomains.
+func domainLinksKey(domain string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "domain:" + domain + "}:links"
+}
+
+// banKey returns the key of an owner's ban.
+func banKey(owner string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "ban:" + owner + "}:ban"
+}
+
+// NormalizeDomain returns the lower-case host of a domain or URL, without port, or an empty string if it has none.
+// Destinations without a scheme are taken as https URLs, as redirects do.
+func NormalizeDomain(destination string) string {
+	destination = strings.TrimSpace(destination)
+	if !strings.Contains(destination, "://") {
+		destination = "https://" + destination
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// parentDomains returns a host and the domains it is a subdomain of, down to the second level:
+// a.b.example.com gives a.b.example.com, b.example.com and example.com. IP addresses have no parents.
+func parentDomains(host string) []string {
+	if host == "" {
+		return nil
+	}
+	domains := []string{host}
+	if net.ParseIP(host) != nil {
+		return domains
+	}
+	for {
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok || !strings.Contains(parent, ".") {
+			return domains
+		}
+		domains = append(domains, parent)
+		host = parent
+	}
+}
+
+// onDomain reports whether a link's destination is on a domain or one of its subdomains.
+func (l *Link) onDomain(domain string) bool {
+	host := NormalizeDomain(l.Destination)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// queueDomainIndex queues the commands that index a link under the domain of its destination and its parent
+// domains, by creation time, so links can be searched by domain. Entries left behind by a change of destination
+// are removed by the searches that come across them.
+func queueDomainIndex(reqCtx context.Context, pipe redis.Pipeliner, link *Link) {
+	for _, domain := range parentDomains(NormalizeDomain(link.Destination)) {
+		pipe.ZAdd(reqCtx, domainLinksKey(domain), redis.Z{Score: float64(link.CreatedAt.UnixMilli()), Member: link.Code})
+	}
+}
+
+// SearchLinks returns a page of the links matching a moderation search, newest first.
+// Pages are read from the domain or owner index starting at the cursor, so a page only costs the links it
+// holds and those the other filters skip. Links written before the domain index existed are only found by
+// domain after their next change.
+func SearchLinks(reqCtx context.Context, search LinkSearch) (*LinkPage, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.SearchLinks")
+	after, err := decodeCursor(search.Cursor)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+
+	var scan indexScan
+	if domain := NormalizeDomain(search.Domain); domain != "" {
+		scan = indexScan{Key: domainLinksKey(domain), Belongs: func(l *Link) bool { return l.onDomain(domain) }}
+	} else {
+		scan = ownerIndexScan(LinkQuery{Owner: search.Owner})
+	}
+	scan.Descending, scan.After = true, after
+	query, now := LinkQuery{Status: search.Status}, time.Now()
+
+	start := time.Now()
+	page, err := seekLinks(reqCtx, scan, search.Limit, func(l *Link) bool {
+		return (search.Owner == "" || l.Owner == search.Owner) && query.matches(l, now)
+	})
+	metrics.ObserveStore("search_links", start, ignore(err, ErrInvalidCursor))
+	tracing.End(span, ignore(err, ErrInvalidCursor))
+	switch {
+	case errors.Is(err, ErrInvalidCursor):
+		return nil, err
+	case err != nil:
+		return nil, storeError(err)
+	}
+	return page, nil
+}
+
+// DisableLink disables a link for moderation: its short URL stops redirecting and shows the reason instead.
+// Returns the disabled link or ErrNotFound.
+func DisableLink(reqCtx context.Context, shortUrl, reason string) (*Link, error) {
+	return UpdateLink(reqCtx, shortUrl, func(link *Link) error {
+		// Disabled on its own account, it stays disabled if its owner is unbanned.
+		link.Flags = LinkFlags{Disabled: true, DisabledReason: reason}
+		return nil
+	})
+}
+
+// EnableLink enables a link disabled for moderation, or because its owner was banned.
+// Returns the enabled link or ErrNotFound.
+func EnableLink(reqCtx context.Context, shortUrl string) (*Link, error) {
+	return UpdateLink(reqCtx, shortUrl, func(link *Link) error {
+		link.Flags = LinkFlags{}
+		return nil
+	})
+}
+
+// BanOwner bans an owner, and disables in the background its links that are not disabled yet, with the
+// reason of the ban. Banning a banned owner updates the reason of the ban; its links already disabled keep
+// their reason. Returns the ban.
+func BanOwner(reqCtx context.Context, owner, reason string) (*Ban, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.BanOwner")
+	ban := &Ban{Owner: owner, Reason: reason, BannedAt: time.Now().UTC()}
+	value, err := json.Marshal(ban)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+
+	banCtx, cancel := withTimeout(reqCtx)
+	start := time.Now()
+	err = storeService.redisClient.Set(banCtx, banKey(owner), value, 0).Err()
+	if err == nil {
+		err = scheduleOwnerSync(banCtx, owner)
+	}
+	metrics.ObserveStore("ban_owner", start, err)
+	cancel()
+	tracing.End(span, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	startOwnerSync(reqCtx, owner)
+	ban.LinksPending = true
+	return ban, nil
+}
+
+// UnbanOwner lifts an owner's ban, and enables in the background the links disabled by it.
+// Returns ErrNotBanned if the owner is not banned.
+func UnbanOwner(reqCtx context.Context, owner string) error {
+	reqCtx, span := tracing.Start(reqCtx, "store.UnbanOwner")
+	banCtx, cancel := withTimeout(reqCtx)
+	start := time.Now()
+	deleted, err := storeService.redisClient.Del(banCtx, banKey(owner)).Result()
+	if err == nil && deleted > 0 {
+		err = scheduleOwnerSync(banCtx, owner)
+	}
+	metrics.ObserveStore("unban_owner", start, err)
+	cancel()
+	tracing.End(span, err)
+	if err != nil {
+		return storeError(err)
+	}
+	if deleted == 0 {
+		return ErrNotBanned
+	}
+
+	startOwnerSync(reqCtx, owner)
+	return nil
+}
+
+// ownerSyncsKey returns the key of the hash of the owners whose links must be brought in line with their ban,
+// by the time of their last ban change.
+func ownerSyncsKey() string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "moderation}:owner-syncs"
+}
+
+// ownerSyncLeaseKey returns the key of the lease of the replica bringing an owner's links in line with its ban.
+// It shares the hash tag of ownerSyncsKey, so both live on the same slot.
+func ownerSyncLeaseKey(owner string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "moderation}:owner-sync:" + owner
+}
+
+// scheduleOwnerSync records that an owner's links must be brought in line with its ban, which just changed.
+func scheduleOwnerSync(reqCtx context.Context, owner string) error {
+	return storeService.redisClient.HSet(reqCtx, ownerSyncsKey(), owner, time.Now().UnixNano()).Err()
+}
+
+// startOwnerSync brings an owner's links in line with its ban in the background, on behalf of the request
+// that changed the ban, which the changes are attributed to in the audit log. It stops when the store is closed;
+// the work left is resumed by resumeOwnerSyncsPeriodically.
+func startOwnerSync(reqCtx context.Context, owner string) {
+	syncCtx, cancel := context.WithCancel(context.WithoutCancel(reqCtx))
+	if storeService.lifetime != nil {
+		context.AfterFunc(storeService.lifetime, cancel)
+	}
+	storeService.writes.Add(1)
+	go func() {
+		defer storeService.writes.Done()
+		defer cancel()
+		if err := syncOwnerLinks(syncCtx, owner); err != nil && syncCtx.Err() == nil {
+			slog.ErrorContext(syncCtx, "Failed to update the links of a banned or unbanned owner, will retry",
+				slog.String("owner", owner), slog.Any("error", err))
+		}
+	}()
+}
+
+// resumeOwnerSyncsPeriodically resumes the owner syncs left undone, by a failure or a replica that stopped,
+// at ownerSyncRetryInterval until ctx is canceled by Close. Every replica runs it; the lease of each owner
+// keeps two replicas from working on the same owner.
+func resumeOwnerSyncsPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(ownerSyncRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !storeService.connected.Load() {
+			continue
+		}
+		listCtx, cancel := withTimeout(ctx)
+		owners, err := storeService.redisClient.HKeys(listCtx, ownerSyncsKey()).Result()
+		cancel()
+		if err != nil {
+			slog.Warn("Failed to list the pending owner syncs", slog.Any("error", err))
+			continue
+		}
+		for _, owner := range owners {
+			if err := syncOwnerLinks(ctx, owner); err != nil && ctx.Err() == nil {
+				slog.Error("Failed to update the links of a banned or unbanned owner, will retry",
+					slog.String("owner", owner), slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// syncOwnerLinks brings the links of an owner in line with its ban: it disables the links of a banned owner
+// that are not disabled yet, and enables the links disabled by a ban once it is lifted. It holds a lease on
+// the owner, renewed as it goes, so one replica at a time works on an owner, and runs again if the ban changes
+// meanwhile. It does nothing if another replica holds the lease, or if the owner has no sync pending.
+func syncOwnerLinks(ctx context.Context, owner string) error {
+	token := uuid.NewString()
+	leaseCtx, cancel := withTimeout(ctx)
+	acquired, err := storeService.redisClient.SetNX(leaseCtx, ownerSyncLeaseKey(owner), token, ownerSyncLease).Result()
+	cancel()
+	if err != nil || !acquired {
+		return err
+	}
+	defer func() {
+		releaseCtx, cancel := withTimeout(context.WithoutCancel(ctx))
+		defer cancel()
+		_ = compareAndDelete(releaseCtx, ownerSyncLeaseKey(owner), "", token)
+	}()
+	renew := func() error {
+		renewCtx, cancel := withTimeout(ctx)
+		defer cancel()
+		return storeService.redisClient.PExpire(renewCtx, ownerSyncLeaseKey(owner), ownerSyncLease).Err()
+	}
+
+	for {
+		readCtx, cancel := withTimeout(ctx)
+		generation, err := storeService.redisClient.HGet(readCtx, ownerSyncsKey(), owner).Result()
+		cancel()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ban, err := GetBan(ctx, owner)
+		if err != nil && !errors.Is(err, ErrNotBanned) {
+			return err
+		}
+
+		start := time.Now()
+		changed, err := updateOwnerLinks(ctx, owner, banChange(ban), renew)
+		metrics.ObserveStore("sync_owner_links", start, err)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Updated the links of a banned or unbanned owner", slog.String("owner", owner),
+			slog.Bool("banned", ban != nil), slog.Int("links", changed), slog.Duration("duration", time.Since(start)))
+
+		// Done, unless the ban changed meanwhile.
+		doneCtx, cancel := withTimeout(ctx)
+		err = compareAndDelete(doneCtx, ownerSyncsKey(), owner, generation)
+		cancel()
+		if !errors.Is(err, errValueChanged) {
+			return err
+		}
+	}
+}
+
+// banChange returns the change bringing a link in line with the ban of its owner, nil if it is not banned.
+// Links are disabled with the reason of the ban unless they already are, and enabled once it is lifted if the
+// ban disabled them. The change returns errUnchanged for links that are in line already.
+func banChange(ban *Ban) func(*Link) error {
+	if ban != nil {
+		return func(link *Link) error {
+			if link.Flags.Disabled {
+				return errUnchanged
+			}
+			link.Flags = LinkFlags{Disabled: true, DisabledReason: ban.Reason, OwnerBanned: true}
+			return nil
+		}
+	}
+	return func(link *Link) error {
+		if !link.Flags.OwnerBanned {
+			return errUnchanged
+		}
+		link.Flags = LinkFlags{}
+		return nil
+	}
+}
+
+// compareAndDelete deletes a key, or the field of a hash if field is not empty, if it holds the given value.
+// Returns errValueChanged if it holds another value.
+func compareAndDelete(reqCtx context.Context, key, field, value string) error {
+	return storeService.redisClient.Watch(reqCtx, func(tx *redis.Tx) error {
+		var current string
+		var err error
+		if field == "" {
+			current, err = tx.Get(reqCtx, key).Result()
+		} else {
+			current, err = tx.HGet(reqCtx, key, field).Result()
+		}
+		if errors.Is(err, redis.Nil) || err == nil && current != value {
+			return errValueChanged
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(reqCtx, func(pipe redis.Pipeliner) error {
+			if field == "" {
+				pipe.Del(reqCtx, key)
+			} else {
+				pipe.HDel(reqCtx, key, field)
+			}
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// updateOwnerLinks applies a change to every link of an owner, and returns the number of links changed.
+// The change returns errUnchanged to leave a link as it is. The owner index is read in batches, and renew
+// is called after each batch of links, to extend the lease of the caller.
+func updateOwnerLinks(reqCtx context.Context, owner string, change func(*Link) error, renew func() error) (int, error) {
+	changed, visited := 0, 0
+	var updateErr error
+	err := ownerIndexScan(LinkQuery{Owner: owner}).each(reqCtx, func(link *Link, _ cursor) bool {
+		_, err := UpdateLink(reqCtx, link.Code, func(l *Link) error {
+			if l.Owner != owner {
+				return errUnchanged
+			}
+			return change(l)
+		})
+		switch {
+		case err == nil:
+			changed++
+		case !errors.Is(err, errUnchanged) && !errors.Is(err, ErrNotFound):
+			updateErr = err
+			return false
+		}
+		if visited++; visited%listBatchSize == 0 {
+			if updateErr = renew(); updateErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	return changed, cmp.Or(err, updateErr)
+}
+
+// GetBan returns an owner's ban, or ErrNotBanned.
+func GetBan(reqCtx context.Context, owner string) (*Ban, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	value, err := storeService.redisClient.Get(reqCtx, banKey(owner)).Result()
+	metrics.ObserveStore("get_ban", start, ignore(err, redis.Nil))
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotBanned
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var ban Ban
+	if err := json.Unmarshal([]byte(value), &ban); err != nil {
+		return nil, fmt.Errorf("decoding ban of %q: %w", owner, err)
+	}
+	if ban.LinksPending, err = storeService.redisClient.HExists(reqCtx, ownerSyncsKey(), owner).Result(); err != nil {
+		return nil, storeError(err)
+	}
+	return &ban, nil
+}
+
+// IsBanned reports whether any of the given owners is banned. Empty owners are ignored.
+func IsBanned(reqCtx context.Context, owners ...string) (bool, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	// Bans live on different slots in Cluster mode, so they are checked one key at a time, in one pipeline.
+	var cmds []*redis.IntCmd
+	start := time.Now()
+	_, err := storeService.redisClient.Pipelined(reqCtx, func(pipe redis.Pipeliner) error {
+		for _, owner := range owners {
+			if owner != "" {
+				cmds = append(cmds, pipe.Exists(reqCtx, banKey(owner)))
+			}
+		}
+		return nil
+	})
+	metrics.ObserveStore("is_banned", start, err)
+	if err != nil {
+		return false, storeError(err)
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParentDomains(t *testing.T) {
+	assert.Equal(t, []string{"a.b.example.com", "b.example.com", "example.com"}, parentDomains(NormalizeDomain("https://A.b.Example.com:8443/path")))
+	assert.Equal(t, []string{"example.com"}, parentDomains(NormalizeDomain("example.com/path")))
+	assert.Equal(t, []string{"localhost"}, parentDomains(NormalizeDomain("http://localhost:8080")))
+	assert.Equal(t, []string{"192.0.2.1"}, parentDomains(NormalizeDomain("192.0.2.1")))
+	assert.Empty(t, parentDomains(NormalizeDomain("")))
+}
+
+func TestSearchLinks(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	domain := fmt.Sprintf("search-%d.example", time.Now().UnixNano())
+	code := domain + "-link"
+
+	require.NoError(t, SaveLink(context.Background(), &Link{Code: code, Destination: "https://www." + domain + "/page", Owner: "searched"}))
+	require.NoError(t, SaveLink(context.Background(), &Link{Code: code + "-other", Destination: "https://not" + domain, Owner: "searched"}))
+
+	// Links are found by their domain and its parent domains, but not by lookalikes.
+	for _, search := range []string{domain, "www." + domain, "https://WWW." + domain + "/"} {
+		page, err := SearchLinks(context.Background(), LinkSearch{Domain: search})
+		require.NoError(t, err)
+		require.Len(t, page.Links, 1, search)
+		assert.Equal(t, code, page.Links[0].Code)
+	}
+	// Links of other owners are skipped, not removed from the index.
+	page, err := SearchLinks(context.Background(), LinkSearch{Domain: domain, Owner: "someone-else"})
+	require.NoError(t, err)
+	assert.Empty(t, page.Links)
+	indexed, err := storeService.redisClient.ZCard(context.Background(), domainLinksKey(domain)).Result()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, indexed)
+
+	// Links moved to another domain are not found by the old one anymore.
+	_, err = UpdateLink(context.Background(), code, func(l *Link) error {
+		l.Destination = "https://elsewhere.example"
+		return nil
+	})
+	require.NoError(t, err)
+	page, err = SearchLinks(context.Background(), LinkSearch{Domain: domain})
+	require.NoError(t, err)
+	assert.Empty(t, page.Links)
+	indexed, err = storeService.redisClient.ZCard(context.Background(), domainLinksKey(domain)).Result()
+	require.NoError(t, err)
+	assert.Zero(t, indexed)
+}
+
+func TestDisableLink(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	code := fmt.Sprintf("disabled-%d", time.Now().UnixNano())
+
+	require.NoError(t, SaveLink(context.Background(), &Link{Code: code, Destination: "https://example.com"}))
+	link, err := DisableLink(context.Background(), code, "phishing")
+	require.NoError(t, err)
+	assert.Equal(t, LinkFlags{Disabled: true, DisabledReason: "phishing"}, link.Flags)
+	// Disabled links cannot be replaced by creating them again, even once expired.
+	assert.ErrorIs(t, SaveLink(context.Background(), &Link{Code: code, Destination: "https://example.com"}), ErrCodeUnavailable)
+	link, err = EnableLink(context.Background(), code)
+	require.NoError(t, err)
+	assert.Equal(t, LinkFlags{}, link.Flags)
+	_, err = DisableLink(context.Background(), code+"-missing", "phishing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	events, err := ListAuditEvents(context.Background(), AuditQuery{Code: code})
+	require.NoError(t, err)
+	require.Len(t, events.Events, 3)
+	assert.Equal(t, AuditActionEnable, events.Events[0].Action)
+	assert.Equal(t, AuditActionDisable, events.Events[1].Action)
+}
+
+func TestBanOwner(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	owner := fmt.Sprintf("banned-%d", time.Now().UnixNano())
+
+	for _, suffix := range []string{"-a", "-b", "-c"} {
+		require.NoError(t, SaveLink(context.Background(), &Link{Code: owner + suffix, Destination: "https://example.com", Owner: owner}))
+	}
+	_, err = DisableLink(context.Background(), owner+"-c", "malware")
+	require.NoError(t, err)
+
+	_, err = GetBan(context.Background(), owner)
+	assert.ErrorIs(t, err, ErrNotBanned)
+	ban, err := BanOwner(context.Background(), owner, "spam")
+	require.NoError(t, err)
+	assert.Equal(t, "spam", ban.Reason)
+	assert.True(t, ban.LinksPending)
+	// Links are disabled in the background.
+	storeService.writes.Wait()
+	banned, err := IsBanned(context.Background(), "", "someone-else", owner)
+	require.NoError(t, err)
+	assert.True(t, banned)
+	stored, err := GetBan(context.Background(), owner)
+	require.NoError(t, err)
+	assert.Equal(t, "spam", stored.Reason)
+	assert.False(t, stored.LinksPending)
+	link, err := GetLink(context.Background(), owner+"-a")
+	require.NoError(t, err)
+	assert.Equal(t, LinkFlags{Disabled: true, DisabledReason: "spam", OwnerBanned: true}, link.Flags)
+
+	// Lifting the ban enables the links it disabled, but not the link disabled on its own account.
+	require.NoError(t, UnbanOwner(context.Background(), owner))
+	storeService.writes.Wait()
+	link, err = GetLink(context.Background(), owner+"-b")
+	require.NoError(t, err)
+	assert.False(t, link.Flags.Disabled)
+	link, err = GetLink(context.Background(), owner+"-c")
+	require.NoError(t, err)
+	assert.Equal(t, "malware", link.Flags.DisabledReason)
+	banned, err = IsBanned(context.Background(), owner)
+	require.NoError(t, err)
+	assert.False(t, banned)
+	assert.ErrorIs(t, UnbanOwner(context.Background(), owner), ErrNotBanned)
+}
+
+func TestSyncOwnerLinks(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	owner := fmt.Sprintf("synced-%d", time.Now().UnixNano())
+	require.NoError(t, SaveLink(context.Background(), &Link{Code: owner, Destination: "https://example.com", Owner: owner}))
+	ban, err := json.Marshal(Ban{Owner: owner, Reason: "spam"})
+	require.NoError(t, err)
+	require.NoError(t, storeService.redisClient.Set(context.Background(), banKey(owner), ban, 0).Err())
+	require.NoError(t, scheduleOwnerSync(context.Background(), owner))
+
+	// Owners whose lease another replica holds are left to it, and resumed once it is gone.
+	require.NoError(t, storeService.redisClient.Set(context.Background(), ownerSyncLeaseKey(owner), "other", time.Minute).Err())
+	require.NoError(t, syncOwnerLinks(context.Background(), owner))
+	link, err := GetLink(context.Background(), owner)
+	require.NoError(t, err)
+	assert.False(t, link.Flags.Disabled)
+
+	require.NoError(t, storeService.redisClient.Del(context.Background(), ownerSyncLeaseKey(owner)).Err())
+	require.NoError(t, syncOwnerLinks(context.Background(), owner))
+	link, err = GetLink(context.Background(), owner)
+	require.NoError(t, err)
+	assert.True(t, link.Flags.OwnerBanned)
+	pending, err := storeService.redisClient.HExists(context.Background(), ownerSyncsKey(), owner).Result()
+	require.NoError(t, err)
+	assert.False(t, pending)
+	leased, err := storeService.redisClient.Exists(context.Background(), ownerSyncLeaseKey(owner)).Result()
+	require.NoError(t, err)
+	assert.Zero(t, leased)
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Statuses of abuse reports.
+const (
+	ReportStatusOpen     = "open"     // Waiting for review.
+	ReportStatusResolved = "resolved" // Reviewed by a moderator.
+)
+
+// Resolutions of abuse reports.
+const (
+	ReportResolutionActioned  = "actioned"  // The report was founded and the link dealt with.
+	ReportResolutionDismissed = "dismissed" // The report was unfounded.
+)
+
+// reportDedupWindow is how long a client cannot report the same link again.
+const reportDedupWindow = 24 * time.Hour
+
+var (
+	// ErrReportNotFound is returned when no abuse report has the requested ID.
+	ErrReportNotFound = errors.New("report not found")
+
+	// ErrDuplicateReport is returned when a client reports the same link twice within reportDedupWindow.
+	ErrDuplicateReport = errors.New("link already reported")
+)
+
+// Report is an abuse report filed by a visitor of a link, reviewed by moderators through the admin API.
+type Report struct {
+	ID         string     `json:"id"` // Increasing with time.
+	Code       string     `json:"code"`
+	Category   string     `json:"category"`
+	Details    string     `json:"details,omitempty"`
+	ClientIP   string     `json:"client_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Status     string     `json:"status"`               // One of the ReportStatus constants.
+	Resolution string     `json:"resolution,omitempty"` // One of the ReportResolution constants, once resolved.
+	Note       string     `json:"note,omitempty"`       // The moderator's note on the resolution.
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ReportPage is a page of abuse reports. NextCursor is empty on the last page.
+type ReportPage struct {
+	Reports    []*Report `json:"reports"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// reportsKey returns the key of one of the structures holding abuse reports: the "data" hash of reports by ID,
+// and the "open" and "resolved" sorted sets of report IDs. They share a hash tag, so they live on the same slot.
+func reportsKey(suffix string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "reports}:" + suffix
+}
+
+// FileReport stores a new open abuse report, assigning its ID, status and creation time.
+// Returns ErrDuplicateReport if the report's client already reported the link recently.
+func FileReport(reqCtx context.Context, report *Report) error {
+	reqCtx, span := tracing.Start(reqCtx, "store.FileReport")
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		tracing.End(span, err)
+		return err
+	}
+	report.ID = id.String()
+	report.Status, report.Resolution, report.Note, report.ResolvedAt = ReportStatusOpen, "", "", nil
+	report.CreatedAt = time.Now().UTC()
+	value, err := json.Marshal(report)
+	if err != nil {
+		tracing.End(span, err)
+		return err
+	}
+
+	start := time.Now()
+	seen := reportsKey("seen:" + tokenHash(report.Code+"\x00"+report.ClientIP))
+	first, err := storeService.redisClient.SetNX(reqCtx, seen, report.ID, reportDedupWindow).Result()
+	if err == nil && first {
+		_, err = storeService.redisClient.TxPipelined(reqCtx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(reqCtx, reportsKey("data"), report.ID, value)
+			pipe.ZAdd(reqCtx, reportsKey(ReportStatusOpen), redis.Z{Member: report.ID})
+			return nil
+		})
+	}
+	metrics.ObserveStore("file_report", start, err)
+	tracing.End(span, err)
+	if err != nil {
+		return storeError(err)
+	}
+	if !first {
+		return ErrDuplicateReport
+	}
+	return nil
+}
+
+// ListReports returns a page of the abuse reports with a status: open reports oldest first, as a queue,
+// and resolved reports newest first. Returns ErrInvalidCursor for a malformed cursor.
+func ListReports(reqCtx context.Context, status string, limit int, cursor string) (*ReportPage, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	limit = min(limit, MaxListLimit)
+	if cursor != "" {
+		if _, err := uuid.Parse(cursor); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	// Every member has the same score, so the sets are ordered by ID, which is ordered by time.
+	bounds := &redis.ZRangeBy{Min: "-", Max: "+", Count: int64(limit) + 1}
+	start := time.Now()
+	var ids []string
+	var err error
+	if status == ReportStatusOpen {
+		if cursor != "" {
+			bounds.Min = "(" + cursor
+		}
+		ids, err = storeService.redisClient.ZRangeByLex(reqCtx, reportsKey(status), bounds).Result()
+	} else {
+		if cursor != "" {
+			bounds.Max = "(" + cursor
+		}
+		ids, err = storeService.redisClient.ZRevRangeByLex(reqCtx, reportsKey(status), bounds).Result()
+	}
+	var values []any
+	if err == nil && len(ids) > 0 {
+		values, err = storeService.redisClient.HMGet(reqCtx, reportsKey("data"), ids...).Result()
+	}
+	metrics.ObserveStore("list_reports", start, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	page := &ReportPage{Reports: []*Report{}}
+	for i, value := range values {
+		if i == limit {
+			page.NextCursor = page.Reports[len(page.Reports)-1].ID
+			break
+		}
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		report, err := decodeReport(data)
+		if err != nil {
+			return nil, err
+		}
+		page.Reports = append(page.Reports, report)
+	}
+	return page, nil
+}
+
+// GetReport returns an abuse report, or ErrReportNotFound.
+func GetReport(reqCtx context.Context, id string) (*Report, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	value, err := storeService.redisClient.HGet(reqCtx, reportsKey("data"), id).Result()
+	metrics.ObserveStore("get_report", start, ignore(err, redis.Nil))
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return decodeReport(value)
+}
+
+// ResolveReport records a moderator's resolution of an abuse report and moves it out of the open queue.
+// Resolving a resolved report replaces its resolution. Returns the resolved report, or ErrReportNotFound.
+func ResolveReport(reqCtx context.Context, id, resolution, note string) (*Report, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.ResolveReport")
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	var report *Report
+	resolve := func(tx *redis.Tx) error {
+		value, err := tx.HGet(reqCtx, reportsKey("data"), id).Result()
+		if errors.Is(err, redis.Nil) {
+			return ErrReportNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if report, err = decodeReport(value); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		report.Status, report.Resolution, report.Note, report.ResolvedAt = ReportStatusResolved, resolution, note, &now
+		updated, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(reqCtx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(reqCtx, reportsKey("data"), id, updated)
+			pipe.ZRem(reqCtx, reportsKey(ReportStatusOpen), id)
+			pipe.ZAdd(reqCtx, reportsKey(ReportStatusResolved), redis.Z{Member: id})
+			return nil
+		})
+		return err
+	}
+
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if err = storeService.redisClient.Watch(reqCtx, resolve, reportsKey("data")); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	metrics.ObserveStore("resolve_report", start, ignore(err, ErrReportNotFound))
+	tracing.End(span, ignore(err, ErrReportNotFound))
+	switch {
+	case errors.Is(err, ErrReportNotFound):
+		return nil, err
+	case err != nil:
+		return nil, storeError(err)
+	}
+	return report, nil
+}
+
+// decodeReport parses an abuse report stored as JSON.
+func decodeReport(value string) (*Report, error) {
+	var report Report
+	if err := json.Unmarshal([]byte(value), &report); err != nil {
+		return nil, fmt.Errorf("decoding report: %w", err)
+	}
+	return &report, nil
+}
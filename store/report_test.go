@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReports(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	require.NoError(t, storeService.redisClient.Del(context.Background(),
+		reportsKey("data"), reportsKey(ReportStatusOpen), reportsKey(ReportStatusResolved)).Err())
+	code := fmt.Sprintf("reported-%d", time.Now().UnixNano())
+
+	var ids []string
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		report := &Report{Code: code, Category: "phishing", ClientIP: ip}
+		require.NoError(t, FileReport(context.Background(), report))
+		assert.Equal(t, ReportStatusOpen, report.Status)
+		ids = append(ids, report.ID)
+	}
+	assert.ErrorIs(t, FileReport(context.Background(), &Report{Code: code, Category: "spam", ClientIP: "192.0.2.1"}), ErrDuplicateReport)
+
+	// Open reports are a queue, oldest first.
+	page, err := ListReports(context.Background(), ReportStatusOpen, 2, "")
+	require.NoError(t, err)
+	require.Len(t, page.Reports, 2)
+	assert.Equal(t, ids[0], page.Reports[0].ID)
+	assert.Equal(t, ids[1], page.NextCursor)
+	page, err = ListReports(context.Background(), ReportStatusOpen, 2, page.NextCursor)
+	require.NoError(t, err)
+	require.Len(t, page.Reports, 1)
+	assert.Equal(t, ids[2], page.Reports[0].ID)
+	assert.Empty(t, page.NextCursor)
+	_, err = ListReports(context.Background(), ReportStatusOpen, 2, "bogus")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	resolved, err := ResolveReport(context.Background(), ids[1], ReportResolutionDismissed, "not phishing")
+	require.NoError(t, err)
+	assert.Equal(t, ReportStatusResolved, resolved.Status)
+	assert.NotNil(t, resolved.ResolvedAt)
+	_, err = ResolveReport(context.Background(), "missing", ReportResolutionDismissed, "")
+	assert.ErrorIs(t, err, ErrReportNotFound)
+
+	page, err = ListReports(context.Background(), ReportStatusOpen, 0, "")
+	require.NoError(t, err)
+	assert.Len(t, page.Reports, 2)
+	page, err = ListReports(context.Background(), ReportStatusResolved, 0, "")
+	require.NoError(t, err)
+	require.Len(t, page.Reports, 1)
+	assert.Equal(t, "not phishing", page.Reports[0].Note)
+	report, err := GetReport(context.Background(), ids[1])
+	require.NoError(t, err)
+	assert.Equal(t, ReportResolutionDismissed, report.Resolution)
+}
@@ -0,0 +1,68 @@
+package store
+
+// Role is the role of a member of a workspace. Each role has the permissions of the roles below it.
+type Role string
+
+// Workspace roles, from the most to the least privileged.
+const (
+	RoleOwner  Role = "owner"  // Manages the workspace itself, including its owners.
+	RoleAdmin  Role = "admin"  // Manages members and invitations, except owners.
+	RoleEditor Role = "editor" // Creates and changes links and folders.
+	RoleViewer Role = "viewer" // Lists links, folders and stats.
+)
+
+// Permission is an action that requires a minimum role in a workspace.
+type Permission string
+
+// Permissions checked by the API.
+const (
+	PermissionViewLinks       Permission = "view_links"
+	PermissionCreateLinks     Permission = "create_links"
+	PermissionEditLinks       Permission = "edit_links"
+	PermissionManageMembers   Permission = "manage_members"
+	PermissionManageWorkspace Permission = "manage_workspace"
+)
+
+// roleRanks orders the roles; unknown roles rank 0 and have no permission.
+var roleRanks = map[Role]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3, RoleOwner: 4}
+
+// permissionRoles maps each permission to the least privileged role that has it.
+var permissionRoles = map[Permission]Role{
+	PermissionViewLinks:       RoleViewer,
+	PermissionCreateLinks:     RoleEditor,
+	PermissionEditLinks:       RoleEditor,
+	PermissionManageMembers:   RoleAdmin,
+	PermissionManageWorkspace: RoleOwner,
+}
+
+// Valid reports whether the role is one of the workspace roles.
+func (r Role) Valid() bool {
+	return roleRanks[r] > 0
+}
+
+// Can reports whether the role has a permission.
+func (r Role) Can(p Permission) bool {
+	required, ok := permissionRoles[p]
+	return ok && r.Valid() && roleRanks[r] >= roleRanks[required]
+}
+
+// CanAssign reports whether a member with this role may change a member's role from one role to another,
+// or grant a role through an invitation (from empty). Admins manage every role but the owner's,
+// which only owners can grant or take away.
+func (r Role) CanAssign(from, to Role) bool {
+	if !r.Can(PermissionManageMembers) || (to != "" && !to.Valid()) {
+		return false
+	}
+	if from == RoleOwner || to == RoleOwner {
+		return r == RoleOwner
+	}
+	return true
+}
+
+// MaxRole returns the more privileged of two roles.
+func MaxRole(a, b Role) Role {
+	if roleRanks[b] > roleRanks[a] {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolePermissions(t *testing.T) {
+	assert.True(t, RoleViewer.Can(PermissionViewLinks))
+	assert.False(t, RoleViewer.Can(PermissionEditLinks))
+	assert.True(t, RoleEditor.Can(PermissionEditLinks))
+	assert.False(t, RoleEditor.Can(PermissionManageMembers))
+	assert.True(t, RoleAdmin.Can(PermissionManageMembers))
+	assert.False(t, RoleAdmin.Can(PermissionManageWorkspace))
+	assert.True(t, RoleOwner.Can(PermissionManageWorkspace))
+	assert.False(t, Role("superuser").Can(PermissionViewLinks))
+	assert.False(t, RoleOwner.Can(Permission("unknown")))
+}
+
+func TestRoleCanAssign(t *testing.T) {
+	assert.True(t, RoleAdmin.CanAssign("", RoleEditor))
+	assert.True(t, RoleAdmin.CanAssign(RoleViewer, RoleAdmin))
+	assert.False(t, RoleAdmin.CanAssign("", RoleOwner))
+	assert.False(t, RoleAdmin.CanAssign(RoleOwner, RoleViewer))
+	assert.True(t, RoleOwner.CanAssign(RoleOwner, RoleAdmin))
+	assert.False(t, RoleEditor.CanAssign("", RoleViewer))
+	assert.False(t, RoleOwner.CanAssign("", Role("superuser")))
+	// Removing a member is a change to no role.
+	assert.True(t, RoleAdmin.CanAssign(RoleEditor, ""))
+	assert.False(t, RoleAdmin.CanAssign(RoleOwner, ""))
+}
+
+func TestMaxRole(t *testing.T) {
+	assert.Equal(t, RoleAdmin, MaxRole(RoleAdmin, RoleViewer))
+	assert.Equal(t, RoleEditor, MaxRole("", RoleEditor))
+	assert.Equal(t, RoleOwner, MaxRole(RoleOwner, RoleOwner))
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrSessionNotFound is returned for session tokens that are unknown, expired or revoked.
+var ErrSessionNotFound = errors.New("session not found")
+
+// tokenBytes is the number of random bytes of session and invitation tokens.
+const tokenBytes = 32
+
+// Session is a login session of a user, identified by an opaque token held by the client.
+type Session struct {
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// sessionKey returns the key of a session. Sessions are stored under a hash of their token,
+// so the tokens themselves never reach Redis.
+func sessionKey(token string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "session:" + tokenHash(token) + "}:session"
+}
+
+// userSessionsKey returns the key of the set of a user's session token hashes, used to revoke them.
+func userSessionsKey(userID string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "user:" + userID + "}:sessions"
+}
+
+// newToken returns a random URL-safe token.
+func newToken() (string, error) {
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// tokenHash returns the hex SHA-256 hash of a session or invitation token.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a session for a user, lasting for the given duration, and returns its token.
+func CreateSession(reqCtx context.Context, userID string, ttl time.Duration) (string, *Session, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	session := &Session{UserID: userID, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	value, err := json.Marshal(session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	start := time.Now()
+	_, err = storeService.redisClient.Pipelined(reqCtx, func(pipe redis.Pipeliner) error {
+		pipe.Set(reqCtx, sessionKey(token), value, ttl)
+		pipe.SAdd(reqCtx, userSessionsKey(userID), tokenHash(token))
+		return nil
+	})
+	metrics.ObserveStore("create_session", start, err)
+	if err != nil {
+		return "", nil, storeError(err)
+	}
+	return token, session, nil
+}
+
+// GetSession returns the session of a token, or ErrSessionNotFound.
+func GetSession(reqCtx context.Context, token string) (*Session, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	value, err := storeService.redisClient.Get(reqCtx, sessionKey(token)).Result()
+	metrics.ObserveStore("get_session", start, ignore(err, redis.Nil))
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var session Session
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return nil, fmt.Errorf("decoding session: %w", err)
+	}
+	return &session, nil
+}
+
+// DeleteSession ends the session of a token.
+func DeleteSession(reqCtx context.Context, token string) error {
+	session, err := GetSession(reqCtx, token)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+	start := time.Now()
+	_, err = storeService.redisClient.Pipelined(reqCtx, func(pipe redis.Pipeliner) error {
+		pipe.Del(reqCtx, sessionKey(token))
+		pipe.SRem(reqCtx, userSessionsKey(session.UserID), tokenHash(token))
+		return nil
+	})
+	metrics.ObserveStore("delete_session", start, err)
+	return storeError(err)
+}
+
+// DeleteUserSessions ends every session of a user except the one of the given token, if any.
+// It is used to log out other devices when a password changes.
+func DeleteUserSessions(reqCtx context.Context, userID, keepToken string) error {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	hashes, err := storeService.redisClient.SMembers(reqCtx, userSessionsKey(userID)).Result()
+	if err == nil {
+		keep := ""
+		if keepToken != "" {
+			keep = tokenHash(keepToken)
+		}
+		_, err = storeService.redisClient.Pipelined(reqCtx, func(pipe redis.Pipeliner) error {
+			for _, hash := range hashes {
+				if hash == keep {
+					continue
+				}
+				pipe.Del(reqCtx, "{"+config.AppConfig.RedisKeyPrefix+"session:"+hash+"}:session")
+				pipe.SRem(reqCtx, userSessionsKey(userID), hash)
+			}
+			return nil
+		})
+	}
+	metrics.ObserveStore("delete_user_sessions", start, err)
+	return storeError(err)
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/redis/go-redis/v9"
+)
+
+// Groupings of link statistics.
+const (
+	GroupByFolder = "folder"
+	GroupByTag    = "tag"
+)
+
+// clicksSuffix names the counter of a link's clicks, stored next to the link.
+const clicksSuffix = "clicks"
+
+// GroupStats aggregates the links of one folder or tag.
+type GroupStats struct {
+	Key    string `json:"key"`            // Folder ID or tag; empty for links without a folder.
+	Name   string `json:"name,omitempty"` // Folder name, when grouping by folder.
+	Links  int    `json:"links"`
+	Clicks int64  `json:"clicks"`
+}
+
+// RecordClick counts a click on a link. The counter expires together with the link.
+// It runs in the background, so redirects do not wait for it; failures are only logged.
+// Close waits for it, so clicks are not lost on shutdown.
+func RecordClick(reqCtx context.Context, link *Link) {
+	storeService.writes.Add(1)
+	go func() {
+		defer storeService.writes.Done()
+		clickCtx, cancel := withTimeout(context.WithoutCancel(reqCtx))
+		defer cancel()
+
+		start := time.Now()
+		_, err := storeService.redisClient.Pipelined(clickCtx, func(pipe redis.Pipeliner) error {
+			counter := subKey(link.Code, clicksSuffix)
+			pipe.Incr(clickCtx, counter)
+			if ttl := link.ttl(time.Now()); ttl > 0 {
+				pipe.Expire(clickCtx, counter, ttl)
+			}
+			return nil
+		})
+		metrics.ObserveStore("record_click", start, err)
+		if err != nil {
+			slog.WarnContext(clickCtx, "Failed to record click", slog.String("short_url", link.Code), slog.Any("error", err))
+		}
+	}()
+}
+
+// LinkStats aggregates the number of links and clicks of an owner's links, grouped by folder or by tag.
+// A link with several tags counts toward each of them, and links in the trash are left out.
+// Groups are sorted by clicks, then links, descending.
+// The owner's links are read in batches, each under its own store timeout, so large owners do not
+// exceed it; only the groups are kept in memory.
+func LinkStats(reqCtx context.Context, owner, groupBy string) ([]GroupStats, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.LinkStats")
+	groups := make(map[string]*GroupStats)
+	start := time.Now()
+	err := eachLinkWithClicks(reqCtx, owner, func(link *Link, clicks int64) {
+		addStats(groups, link, clicks, groupBy)
+	})
+	metrics.ObserveStore("link_stats", start, err)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var names map[string]string
+	if groupBy == GroupByFolder {
+		folders, err := ListFolders(reqCtx, owner)
+		if err != nil {
+			return nil, err
+		}
+		names = make(map[string]string, len(folders))
+		for _, folder := range folders {
+			names[folder.ID] = folder.Name
+		}
+	}
+	return sortedStats(groups, names), nil
+}
+
+// eachLinkWithClicks visits an owner's links with their click counts. The links and their counters are read
+// in batches of listBatchSize, each under its own store timeout.
+func eachLinkWithClicks(reqCtx context.Context, owner string, visit func(link *Link, clicks int64)) error {
+	batch := make([]*Link, 0, listBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		clicksCtx, cancel := withTimeout(reqCtx)
+		defer cancel()
+		cmds := make([]*redis.StringCmd, len(batch))
+		_, err := storeService.redisClient.Pipelined(clicksCtx, func(pipe redis.Pipeliner) error {
+			for i, link := range batch {
+				cmds[i] = pipe.Get(clicksCtx, subKey(link.Code, clicksSuffix))
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		for i, cmd := range cmds {
+			// Links never clicked have no counter.
+			n, _ := cmd.Int64()
+			visit(batch[i], n)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	var flushErr error
+	err := ownerIndexScan(LinkQuery{Owner: owner}).each(reqCtx, func(link *Link, _ cursor) bool {
+		if batch = append(batch, link); len(batch) == listBatchSize {
+			flushErr = flush()
+		}
+		return flushErr == nil
+	})
+	if err == nil && flushErr == nil {
+		flushErr = flush()
+	}
+	return cmp.Or(err, flushErr)
+}
+
+// addStats counts a link and its clicks in the groups of its folder or tags. Links in the trash are left out.
+func addStats(groups map[string]*GroupStats, link *Link, clicks int64, groupBy string) {
+	if link.DeletedAt != nil {
+		return
+	}
+	add := func(key string) {
+		group, ok := groups[key]
+		if !ok {
+			group = &GroupStats{Key: key}
+			groups[key] = group
+		}
+		group.Links++
+		group.Clicks += clicks
+	}
+	if groupBy == GroupByTag {
+		for _, tag := range link.Tags {
+			add(tag)
+		}
+	} else {
+		add(link.Folder)
+	}
+}
+
+// sortedStats returns the groups by clicks, then links, descending. Folder groups are named after the given
+// folder names.
+func sortedStats(groups map[string]*GroupStats, names map[string]string) []GroupStats {
+	stats := make([]GroupStats, 0, len(groups))
+	for _, group := range groups {
+		group.Name = names[group.Key]
+		stats = append(stats, *group)
+	}
+	slices.SortFunc(stats, func(a, b GroupStats) int {
+		return cmp.Or(cmp.Compare(b.Clicks, a.Clicks), cmp.Compare(b.Links, a.Links), cmp.Compare(a.Key, b.Key))
+	})
+	return stats
+}
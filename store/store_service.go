@@ -2,91 +2,452 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
-	"strconv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
 	"github.com/redis/go-redis/v9"
 )
 
+// Backoff bounds used while waiting for Redis to become reachable at startup.
+const (
+	initialConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff     = 30 * time.Second
+)
+
 var (
 	storeService  = &StoreService{}
-	ctx           = context.Background()
 	CacheDuration time.Duration
+
+	// ErrNotConnected is returned by Ping until the first successful connection to Redis.
+	ErrNotConnected = errors.New("store is not connected yet")
 )
 
 // StoreService provides methods to interact with the Redis store.
 type StoreService struct {
-	redisClient *redis.Client
+	redisClient   redis.UniversalClient
+	connected     atomic.Bool   // Whether Redis has been reached at least once since startup.
+	invalidations *redis.PubSub // Subscription to link cache invalidations from other replicas.
+
+	// lifetime is canceled by Close, stopping the background work of the store.
+	lifetime context.Context
+	stop     context.CancelFunc
+
+	// writes tracks the writes made in the background, such as click counts, which Close waits for.
+	writes sync.WaitGroup
 }
 
 // InitializeStoreService initializes the StoreService singleton with the Redis client.
 // It sets the CacheDuration variable based on the configured value, and creates a new Redis client.
+// The connection is established in the background, retrying with exponential backoff,
+// so an unreachable Redis at startup leaves the service running but not ready instead of crashing it.
 func InitializeStoreService() *StoreService {
-	var cacheDurationInt int
-	if config.AppConfig.CacheDuration == "" {
-		cacheDurationInt = 1440
-		config.AppConfig.CacheDuration = "1440"
-		log.Printf("CacheDuration not set, using default value: %d", cacheDurationInt)
-	} else {
-		var err error
-		cacheDurationInt, err = strconv.Atoi(config.AppConfig.CacheDuration)
+	// Set the CacheDuration variable to the configured value.
+	CacheDuration = config.AppConfig.CacheDuration
+
+	// Create a new Redis client.
+	redisClient, err := newRedisClient(config.AppConfig)
+	if err != nil {
+		log.Fatalf("Invalid Redis configuration: %v", err)
+	}
+
+	// Set the Redis client to the StoreService singleton.
+	storeService.redisClient = redisClient
+	storeService.connected.Store(false)
+	storeService.lifetime, storeService.stop = context.WithCancel(context.Background())
+
+	// Keep hot links in memory, and drop them when another replica changes them.
+	initLinkCache(config.AppConfig)
+	initCodeFilter(config.AppConfig)
+	storeService.invalidations = subscribeInvalidations(storeService.lifetime, redisClient)
+
+	// Ping the Redis server in the background until the connection succeeds.
+	go connectWithRetry(storeService.lifetime, redisClient)
+	go purgeTrashPeriodically(storeService.lifetime)
+	go rebuildCodeFilterPeriodically(storeService.lifetime, redisClient)
+	go resumeOwnerSyncsPeriodically(storeService.lifetime)
+	return storeService
+}
+
+// connectWithRetry pings Redis until it answers, doubling the wait between attempts up to maxConnectBackoff.
+// Once Redis answers, the store is marked as connected and the short code filter is rebuilt.
+// It gives up when ctx is canceled by Close.
+func connectWithRetry(ctx context.Context, redisClient redis.UniversalClient) {
+	backoff := initialConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err := redisClient.Ping(ctx).Err()
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil || errors.Is(err, redis.ErrClosed) {
+			// The store was closed while we were still waiting for Redis; give up.
+			return
+		}
+
+		slog.Warn("Failed to connect to Redis, retrying",
+			slog.Int("attempt", attempt),
+			slog.Duration("backoff", backoff),
+			slog.Any("error", err),
+		)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+
+	storeService.connected.Store(true)
+	slog.Info("Redis connected successfully", slog.String("mode", config.AppConfig.RedisMode))
+
+	// Load the existing short codes into the code filter; lookups skip the filter until this succeeds.
+	if err := rebuildCodeFilter(ctx, redisClient); err != nil && ctx.Err() == nil && !errors.Is(err, redis.ErrClosed) {
+		slog.Error("Failed to rebuild the short code filter, lookups will not use it", slog.Any("error", err))
+	}
+}
+
+// Close closes the Redis client and the cache invalidation subscription, waiting for in-flight commands
+// and background writes to finish. It also stops the background connection attempts if Redis was never reached.
+// It is called once the server has stopped handling requests, so no background write starts meanwhile.
+func Close() error {
+	if storeService.redisClient == nil {
+		return nil
+	}
+	storeService.stop()
+	// Each background write is bounded by the store timeout.
+	storeService.writes.Wait()
+	if storeService.invalidations != nil {
+		if err := storeService.invalidations.Close(); err != nil {
+			slog.Warn("Failed to close the link cache invalidation subscription", slog.Any("error", err))
+		}
+	}
+	return storeService.redisClient.Close()
+}
+
+// Ping checks that the store is connected and that Redis currently answers.
+// It is used as the readiness check of the store.
+func Ping(reqCtx context.Context) error {
+	if storeService.redisClient == nil || !storeService.connected.Load() {
+		return ErrNotConnected
+	}
+	return storeService.redisClient.Ping(reqCtx).Err()
+}
+
+// SaveLink stores a new link and its metadata under its short code. Only an expired link can be replaced.
+// The creation and update timestamps are set when missing, and links without an expiry expire
+// after the configured cache duration, if any. Links with an owner are added to the owner's index.
+// The creation is recorded in the audit log in the same transaction, attributed to the actor of the context.
+//
+// Parameters:
+//
+//	reqCtx - the context of the request, used for tracing and cancellation
+//	link - the link to be stored; its timestamps and expiry are updated in place
+//
+// Returns ErrCodeTaken if the short code belongs to a live link, ErrCodeUnavailable if it belongs to a link
+// in the trash or disabled, or is quarantined, or an error wrapping ErrUnavailable if the link could not be stored.
+func SaveLink(reqCtx context.Context, link *Link) error {
+	reqCtx, span := tracing.Start(reqCtx, "store.SaveLink", tracing.ShortCode(link.Code))
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = now
+	}
+	if link.UpdatedAt.IsZero() {
+		link.UpdatedAt = link.CreatedAt
+	}
+	if link.ExpiresAt == nil && CacheDuration > 0 {
+		expiresAt := link.CreatedAt.Add(CacheDuration)
+		link.ExpiresAt = &expiresAt
+	}
+	link.Version = 1
+	value, err := encodeLink(link)
+	if err != nil {
+		tracing.End(span, err)
+		return err
+	}
+	event, eventValue, err := encodeAuditEvent(reqCtx, AuditActionCreate, nil, link)
+	if err != nil {
+		tracing.End(span, err)
+		return err
+	}
+
+	// The code is checked and taken in one transaction, so a link cannot replace one being written meanwhile.
+	take := func(tx *redis.Tx) error {
+		if err := checkCodeAvailable(reqCtx, tx, link.Code, now); err != nil {
+			return err
+		}
+		_, err := tx.TxPipelined(reqCtx, func(pipe redis.Pipeliner) error {
+			queueLinkWrite(reqCtx, pipe, link, value, now)
+			// A link replacing an expired one starts a new history.
+			pipe.Del(reqCtx, subKey(link.Code, versionsSuffix))
+			queueLinkVersion(reqCtx, pipe, link, now)
+			queueAudit(reqCtx, pipe, eventValue)
+			return nil
+		})
+		return err
+	}
+
+	start := time.Now()
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err = storeService.redisClient.Watch(reqCtx, take, key(link.Code), subKey(link.Code, quarantineSuffix))
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if errors.Is(err, ErrCodeUnavailable) || errors.Is(err, ErrCodeTaken) {
+		metrics.ObserveStore("save_link", start, nil)
+		tracing.End(span, nil)
+		return err
+	}
+	if err == nil {
+		// The owner and domain indexes live on other slots in Cluster mode, so they cannot be part of the transaction.
+		pipe := storeService.redisClient.Pipeline()
+		queueOwnerIndex(reqCtx, pipe, link)
+		queueDomainIndex(reqCtx, pipe, link)
+		_, err = pipe.Exec(reqCtx)
+	}
+	metrics.ObserveStore("save_link", start, err)
+	tracing.End(span, err)
+	if err != nil {
+		// If an error occurs, return the error.
+		return storeError(err)
+	}
+
+	linkChanged(reqCtx, link.Code)
+	recordAudit(reqCtx, event, eventValue)
+	// If the link was stored successfully, return nil.
+	return nil
+}
+
+// maxUpdateAttempts bounds the retries of a link update that raced with another write of the same link.
+const maxUpdateAttempts = 5
+
+// UpdateLink applies a change to the link stored under a short code and saves it, setting its update time.
+// The change is made with optimistic locking: if the link is written concurrently, it is read again and
+// the change applied again. The change function may return an error, such as ErrNotFound for a link the
+// caller may not modify, to abort the update; that error is returned as is.
+// The change is recorded in the audit log in the same transaction, as a deletion, restoration or transfer
+// when it is one, attributed to the actor of the context.
+//
+// Returns the updated link, ErrNotFound if no link exists for the short code, or an error wrapping
+// ErrUnavailable if the store could not be reached.
+func UpdateLink(reqCtx context.Context, shortUrl string, change func(*Link) error) (*Link, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.UpdateLink", tracing.ShortCode(shortUrl))
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	var before, updated *Link
+	var event *AuditEvent
+	var eventValue string
+	var changeErr error
+	update := func(tx *redis.Tx) error {
+		value, err := tx.Get(reqCtx, key(shortUrl)).Result()
 		if err != nil {
-			log.Fatalf("Error parsing CacheDuration: %v", err)
+			return err
+		}
+		link, err := decodeLink(shortUrl, value)
+		if err != nil {
+			return fmt.Errorf("decoding link %q: %w", shortUrl, err)
+		}
+		before = link.clone()
+		if changeErr = change(link); changeErr != nil {
+			return changeErr
+		}
+		now := time.Now().UTC()
+		link.UpdatedAt = now
+		link.Version = before.Version + 1
+		if value, err = encodeLink(link); err != nil {
+			return err
 		}
+		if event, eventValue, err = encodeAuditEvent(reqCtx, updateAction(before, link), before, link); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(reqCtx, func(pipe redis.Pipeliner) error {
+			queueLinkWrite(reqCtx, pipe, link, value, now)
+			queueLinkVersion(reqCtx, pipe, link, now)
+			queueAudit(reqCtx, pipe, eventValue)
+			return nil
+		})
+		updated = link
+		return err
 	}
 
-	// Set the CacheDuration variable to the parsed value.
-	CacheDuration = time.Duration(cacheDurationInt) * time.Minute
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if err = storeService.redisClient.Watch(reqCtx, update, key(shortUrl)); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if changeErr != nil {
+		tracing.End(span, nil)
+		return nil, changeErr
+	}
+	if err == nil {
+		// The owner and domain indexes live on other slots in Cluster mode, so they cannot be part of the transaction.
+		pipe := storeService.redisClient.Pipeline()
+		queueOwnerIndex(reqCtx, pipe, updated)
+		queueDomainIndex(reqCtx, pipe, updated)
+		_, err = pipe.Exec(reqCtx)
+	}
+	metrics.ObserveStore("update_link", start, err)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
 
-	// Create a new Redis client.
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     config.AppConfig.RedisURL + ":" + config.AppConfig.RedisPort,
-		Password: config.AppConfig.RedisPassword,
-		DB:       0,
-	})
+	linkChanged(reqCtx, shortUrl)
+	recordAudit(reqCtx, event, eventValue)
+	return updated, nil
+}
 
-	// Ping the Redis server to check the connection.
-	if _, err := redisClient.Ping(ctx).Result(); err != nil {
-		log.Panicf("Failed to connect to Redis: %v", err)
+// queueLinkWrite queues the command that stores an encoded link on a pipeline.
+// The link is stored as a JSON document; expired links are kept a while longer so they can be reported as such.
+func queueLinkWrite(reqCtx context.Context, pipe redis.Pipeliner, link *Link, value string, now time.Time) {
+	pipe.Set(reqCtx, key(link.Code), value, link.ttl(now))
+}
+
+// queueOwnerIndex queues the command that indexes a link under its owner, by creation time,
+// so owners can list their links. Links without an owner are not indexed.
+func queueOwnerIndex(reqCtx context.Context, pipe redis.Pipeliner, link *Link) {
+	if link.Owner != "" {
+		pipe.ZAdd(reqCtx, ownerLinksKey(link.Owner), redis.Z{Score: float64(link.CreatedAt.UnixMilli()), Member: link.Code})
 	}
+}
 
-	log.Printf(">> Redis connected successfully at %s:%s", config.AppConfig.RedisURL, config.AppConfig.RedisPort)
-	// Set the Redis client to the StoreService singleton.
-	storeService.redisClient = redisClient
-	return storeService
+// linkChanged makes a written link visible everywhere.
+// Replicas may have cached the short code as missing, or cached an older version; drop it everywhere.
+// The invalidation message also adds the code to the other replicas' code filters.
+func linkChanged(reqCtx context.Context, shortUrl string) {
+	addToCodeFilter(shortUrl)
+	if err := InvalidateLink(reqCtx, shortUrl); err != nil {
+		slog.WarnContext(reqCtx, "Failed to publish link cache invalidation", slog.String("short_url", shortUrl), slog.Any("error", err))
+	}
 }
 
-// SaveUrlMapping stores the mapping between a short URL and its original long URL in the Redis store.
-// The mapping is associated with a specific user ID and is set to expire after the configured cache duration.
+// SaveUrlMapping stores the mapping between a short URL and its original long URL in the Redis store,
+// as a link owned by the given user ID that expires after the configured cache duration.
 //
 // Parameters:
 //
+//	reqCtx - the context of the request, used for tracing and cancellation
 //	shortUrl - the short URL to be stored
 //	longUrl - the original URL associated with the short URL
 //	userId - the user ID associated with the short URL
 //
-// Returns an error if the mapping could not be stored.
-func SaveUrlMapping(shortUrl, longUrl, userId string) error {
-	// Attempt to set the short URL and long URL mapping in the Redis store with an expiration duration.
-	if err := storeService.redisClient.Set(ctx, shortUrl, longUrl, CacheDuration).Err(); err != nil {
-		// If an error occurs, return the error.
-		return err
+// Returns an error wrapping ErrUnavailable if the mapping could not be stored.
+func SaveUrlMapping(reqCtx context.Context, shortUrl, longUrl, userId string) error {
+	return SaveLink(reqCtx, &Link{Code: shortUrl, Destination: longUrl, Owner: userId})
+}
+
+// GetLink retrieves the link stored under a short code.
+// It returns ErrNotFound if no link exists for the short code, ErrDeleted if the link is in the trash,
+// ErrExpired if the link has expired, and an error wrapping ErrUnavailable if the store could not be reached in time.
+// The request context is used to attach the lookup to the request's trace, and its cancellation
+// ends the wait for the result. The returned link belongs to the caller.
+//
+// Codes rejected by the short code filter are reported as missing right away.
+// Other lookups are served from the in-process link cache when possible, including codes recently found
+// to be missing. Concurrent cache misses for the same short code share a single Redis lookup.
+func GetLink(reqCtx context.Context, shortUrl string) (*Link, error) {
+	// Codes the filter has never seen do not exist; reject them before they fill the cache.
+	if !mayExist(shortUrl) {
+		return nil, ErrNotFound
 	}
-	// If the mapping was stored successfully, return nil.
-	return nil
+
+	var link *Link
+	if cached, ok := lookupCachedLink(shortUrl); ok {
+		if cached.link == nil {
+			return nil, ErrNotFound
+		}
+		link = cached.link
+	} else {
+		// The shared lookup must not be canceled when the request that started it goes away,
+		// since other requests may be waiting for its result; it is still bounded by the store timeout.
+		result := lookups.DoChan(shortUrl, func() (any, error) {
+			fetchCtx, cancel := withTimeout(context.WithoutCancel(reqCtx))
+			defer cancel()
+			return fetchLink(fetchCtx, shortUrl)
+		})
+		select {
+		case <-reqCtx.Done():
+			return nil, reqCtx.Err()
+		case r := <-result:
+			if r.Err != nil {
+				return nil, r.Err
+			}
+			link = r.Val.(*Link)
+		}
+	}
+
+	if link.DeletedAt != nil {
+		return nil, ErrDeleted
+	}
+	if link.Expired(time.Now()) {
+		return nil, ErrExpired
+	}
+	return link.clone(), nil
 }
 
-// RetrieveInitialUrl retrieves the original URL from the Redis store given a short URL.
-// It returns an empty string if the mapping could not be found.
-func RetrieveInitialUrl(shortUrl string) string {
-	// Attempt to get the original URL associated with the given short URL from the Redis store.
-	result, err := storeService.redisClient.Get(ctx, shortUrl).Result()
+// RetrieveInitialUrl retrieves the original URL given a short URL.
+// It returns the same errors as GetLink.
+func RetrieveInitialUrl(reqCtx context.Context, shortUrl string) (string, error) {
+	link, err := GetLink(reqCtx, shortUrl)
+	if err != nil {
+		return "", err
+	}
+	return link.Destination, nil
+}
+
+// fetchLink retrieves a link from the Redis store given a short code, and caches the result.
+// Missing links are cached as such; failed lookups are not cached.
+func fetchLink(reqCtx context.Context, shortUrl string) (*Link, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.GetLink", tracing.ShortCode(shortUrl))
+	// Attempt to get the link stored under the given short code from the Redis store.
+	start := time.Now()
+	value, err := storeService.redisClient.Get(reqCtx, key(shortUrl)).Result()
+	err = storeError(err)
+	if err == nil && value == "" {
+		// A link without a destination cannot be followed.
+		err = ErrNotFound
+	}
+	if errors.Is(err, ErrNotFound) {
+		// A missing key is a normal lookup miss, not a failed operation.
+		metrics.ObserveStore("get_link", start, nil)
+		tracing.End(span, nil)
+		cacheLink(shortUrl, cachedLink{})
+		if codeFilterReady.Load() {
+			metrics.RecordBloomFilterFalsePositive()
+		}
+		return nil, err
+	}
+	metrics.ObserveStore("get_link", start, err)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+
+	link, err := decodeLink(shortUrl, value)
+	tracing.End(span, err)
 	if err != nil {
-		// If the mapping could not be found, log an error message and return an empty string.
-		log.Printf("Failed to retrieve initial url: %v", err)
-		return ""
+		return nil, fmt.Errorf("decoding link %q: %w", shortUrl, err)
 	}
-	return result
+	cacheLink(shortUrl, cachedLink{link: link})
+	return link, nil
+}
+
+// withTimeout bounds a store call made on behalf of a request by the configured store timeout,
+// so a slow or unreachable Redis fails the request quickly instead of holding it open.
+func withTimeout(reqCtx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(reqCtx, config.AppConfig.StoreTimeout)
 }
@@ -2,71 +2,209 @@ package store
 
 import (
 	"context"
+	"os"
 	"testing"
 
+	"github.com/drunkleen/go-url-shortner/cache"
 	"github.com/drunkleen/go-url-shortner/config"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestSaveUrlMapping(t *testing.T) {
+// TestMain loads the configuration once, since every store test relies on it.
+func TestMain(m *testing.M) {
 	config.LoadConfig()
+	os.Exit(m.Run())
+}
+
+// deleteLinks removes links left behind by previous runs, since creating a link never replaces a live one.
+func deleteLinks(t *testing.T, codes ...string) {
+	t.Helper()
+	for _, code := range codes {
+		err := storeService.redisClient.Del(context.Background(), key(code), subKey(code, versionsSuffix),
+			subKey(code, clicksSuffix), subKey(code, quarantineSuffix)).Err()
+		assert.NoError(t, err)
+	}
+}
+
+func TestSaveUrlMapping(t *testing.T) {
 	// Initialize the StoreService singleton with a mock Redis client.
 	_ = InitializeStoreService()
+	deleteLinks(t, "short-url-1", "", "short-url-4", "short-url-5")
 	// Test case 1: Successful mapping storage.
 	shortUrl1 := "short-url-1"
 	longUrl1 := "long-url-1"
 	userId1 := "user-id-1"
-	err := SaveUrlMapping(shortUrl1, longUrl1, userId1)
+	err := SaveUrlMapping(context.Background(), shortUrl1, longUrl1, userId1)
 	assert.NoError(t, err)
 
 	// Test case 3: Empty short URL.
 	shortUrl2 := ""
 	longUrl2 := "long-url-2"
 	userId2 := "user-id-2"
-	err = SaveUrlMapping(shortUrl2, longUrl2, userId2)
+	err = SaveUrlMapping(context.Background(), shortUrl2, longUrl2, userId2)
 	assert.NoError(t, err)
 
 	// Test case 4: Empty long URL.
 	shortUrl3 := "short-url-4"
 	longUrl3 := ""
 	userId3 := "user-id-"
-	err = SaveUrlMapping(shortUrl3, longUrl3, userId3)
+	err = SaveUrlMapping(context.Background(), shortUrl3, longUrl3, userId3)
 	assert.NoError(t, err)
 
 	// Test case 5: Empty user ID.
-	shortUrl4 := "short-url-4"
+	shortUrl4 := "short-url-5"
 	longUrl4 := "long-url-4"
 	userId4 := ""
-	err = SaveUrlMapping(shortUrl4, longUrl4, userId4)
+	err = SaveUrlMapping(context.Background(), shortUrl4, longUrl4, userId4)
 	assert.NoError(t, err)
+
+	// Test case 6: Live links are never replaced.
+	err = SaveUrlMapping(context.Background(), shortUrl1, "long-url-6", userId1)
+	assert.ErrorIs(t, err, ErrCodeTaken)
 }
 
 func TestRetrieveInitialUrl(t *testing.T) {
 
-	storeService.redisClient = redis.NewClient(&redis.Options{
-		Addr:     config.AppConfig.RedisURL + ":" + config.AppConfig.RedisPort,
-		Password: config.AppConfig.RedisPassword,
-		DB:       0,
-	})
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
 
 	// Test case 1: Existing short URL
 	shortUrl := "existing-short-url"
 	longUrl := "https://example.com"
-	err := storeService.redisClient.Set(context.Background(), shortUrl, longUrl, 0).Err()
+	err = storeService.redisClient.Set(context.Background(), key(shortUrl), longUrl, 0).Err()
+	assert.NoError(t, err)
+	// Written behind the store's back, the code must be added to the filter as saving a link does.
+	addToCodeFilter(shortUrl)
+	result, err := RetrieveInitialUrl(context.Background(), shortUrl)
 	assert.NoError(t, err)
-	result := RetrieveInitialUrl(shortUrl)
 	assert.Equal(t, longUrl, result)
 
 	// Test case 2: Non-existent short URL
 	shortUrl = "non-existent-short-url"
-	result = RetrieveInitialUrl(shortUrl)
+	result, err = RetrieveInitialUrl(context.Background(), shortUrl)
+	assert.ErrorIs(t, err, ErrNotFound)
 	assert.Empty(t, result)
 
 	// Test case 3: Nil error but empty result
 	shortUrl = "nil-error-short-url"
-	err = storeService.redisClient.Set(context.Background(), shortUrl, "", 0).Err()
+	err = storeService.redisClient.Set(context.Background(), key(shortUrl), "", 0).Err()
 	assert.NoError(t, err)
-	result = RetrieveInitialUrl(shortUrl)
+	result, err = RetrieveInitialUrl(context.Background(), shortUrl)
+	assert.ErrorIs(t, err, ErrNotFound)
 	assert.Empty(t, result)
 }
+
+func TestRetrieveInitialUrlCache(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	linkCache = cache.NewLRU[string, cachedLink](10)
+	defer func() { linkCache = cache.NewLRU[string, cachedLink](0) }()
+
+	// A missing code is remembered as missing until the link is created.
+	shortUrl := "cached-short-url"
+	assert.NoError(t, storeService.redisClient.Del(context.Background(), key(shortUrl)).Err())
+	_, err = RetrieveInitialUrl(context.Background(), shortUrl)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, storeService.redisClient.Set(context.Background(), key(shortUrl), "https://example.com/a", 0).Err())
+	_, err = RetrieveInitialUrl(context.Background(), shortUrl)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Saving the link invalidates the negative entry.
+	deleteLinks(t, shortUrl)
+	assert.NoError(t, SaveUrlMapping(context.Background(), shortUrl, "https://example.com/b", "user-id"))
+	assert.Equal(t, "https://example.com/b", retrieve(t, shortUrl))
+
+	// Later lookups are served from memory.
+	assert.NoError(t, storeService.redisClient.Set(context.Background(), key(shortUrl), "https://example.com/c", 0).Err())
+	assert.Equal(t, "https://example.com/b", retrieve(t, shortUrl))
+	assert.NoError(t, InvalidateLink(context.Background(), shortUrl))
+	assert.Equal(t, "https://example.com/c", retrieve(t, shortUrl))
+}
+
+func TestCodeFilter(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	redisClient := redis.NewClient(opts)
+	storeService.redisClient = redisClient
+	defer initCodeFilter(config.Config{})
+
+	existing, lost := "filtered-existing", "filtered-lost"
+	deleteLinks(t, lost)
+	assert.NoError(t, redisClient.Set(context.Background(), key(existing), "https://example.com", 0).Err())
+	assert.NoError(t, redisClient.Set(context.Background(), subKey(existing, "clicks"), 1, 0).Err())
+
+	// Every code may exist until the filter is rebuilt from the store.
+	initCodeFilter(config.Config{BloomFilterCapacity: 100000, BloomFilterFPRate: 0.001})
+	assert.True(t, mayExist("filtered-missing"))
+
+	assert.NoError(t, rebuildCodeFilter(context.Background(), redisClient))
+	assert.True(t, mayExist(existing))
+	assert.False(t, mayExist("filtered-missing"))
+	assert.False(t, mayExist(subKey(existing, "clicks")))
+	_, err = RetrieveInitialUrl(context.Background(), "filtered-missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Saved codes are added to the filter.
+	deleteLinks(t, "filtered-new")
+	assert.NoError(t, SaveUrlMapping(context.Background(), "filtered-new", "https://example.com/new", "user-id"))
+	assert.True(t, mayExist("filtered-new"))
+	assert.Equal(t, "https://example.com/new", retrieve(t, "filtered-new"))
+
+	// Codes created on another replica whose invalidation message was lost are picked up by the next rebuild.
+	assert.NoError(t, redisClient.Set(context.Background(), key(lost), "https://example.com/lost", 0).Err())
+	assert.False(t, mayExist(lost))
+	refreshCodeFilter(context.Background(), redisClient, "test")
+	assert.True(t, mayExist(lost))
+}
+
+func TestEscapeGlob(t *testing.T) {
+	assert.Equal(t, `app:`, escapeGlob("app:"))
+	assert.Equal(t, `a\*b\?\[c\]\\`, escapeGlob(`a*b?[c]\`))
+}
+
+// retrieve looks up a short URL that is expected to exist.
+func retrieve(t *testing.T, shortUrl string) string {
+	t.Helper()
+	result, err := RetrieveInitialUrl(context.Background(), shortUrl)
+	assert.NoError(t, err)
+	return result
+}
+
+func TestRetrieveInitialUrlUnavailable(t *testing.T) {
+	// Nothing listens on this port, so the lookup fails instead of missing.
+	storeService.redisClient = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	_, err := RetrieveInitialUrl(context.Background(), "unavailable-short-url")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.NotErrorIs(t, err, ErrNotFound)
+
+	// Failures are not cached as misses.
+	_, ok := linkCache.Get("unavailable-short-url")
+	assert.False(t, ok)
+}
+
+func TestCloseWaitsForClicks(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	code := "close-clicks"
+	storeService.redisClient = redis.NewClient(opts)
+	deleteLinks(t, code)
+
+	// Close the store of this test only, leaving the shared subscription alone.
+	invalidations := storeService.invalidations
+	storeService.invalidations = nil
+	storeService.lifetime, storeService.stop = context.WithCancel(context.Background())
+	defer func() { storeService.invalidations = invalidations }()
+
+	for range 20 {
+		RecordClick(context.Background(), &Link{Code: code})
+	}
+	assert.NoError(t, Close())
+
+	storeService.redisClient = redis.NewClient(opts)
+	clicks, err := storeService.redisClient.Get(context.Background(), subKey(code, clicksSuffix)).Int()
+	assert.NoError(t, err)
+	assert.Equal(t, 20, clicks)
+}
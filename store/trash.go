@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/redis/go-redis/v9"
+)
+
+// quarantineSuffix names the marker keeping the code of a purged link from being reused, stored next to the link.
+const quarantineSuffix = "quarantine"
+
+// trashKey returns the key of the sorted set indexing the links in the trash by deletion time,
+// so the purge job finds the links due without scanning every link.
+func trashKey() string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "trash}:links"
+}
+
+// checkCodeAvailable returns ErrCodeUnavailable if a short code belongs to a link in the trash or disabled,
+// or is quarantined, and ErrCodeTaken if it belongs to a live link. Only the codes of expired links can be
+// taken over. It reads with the transaction's connection, so its keys are watched by the caller.
+func checkCodeAvailable(reqCtx context.Context, tx *redis.Tx, shortUrl string, now time.Time) error {
+	value, err := tx.Get(reqCtx, key(shortUrl)).Result()
+	switch {
+	case err == nil:
+		existing, err := decodeLink(shortUrl, value)
+		if err != nil {
+			return fmt.Errorf("decoding link %q: %w", shortUrl, err)
+		}
+		if existing.DeletedAt != nil || existing.Flags.Disabled {
+			return ErrCodeUnavailable
+		}
+		if !existing.Expired(now) {
+			return ErrCodeTaken
+		}
+	case !errors.Is(err, redis.Nil):
+		return err
+	}
+
+	quarantined, err := tx.Exists(reqCtx, subKey(shortUrl, quarantineSuffix)).Result()
+	if err != nil {
+		return err
+	}
+	if quarantined > 0 {
+		return ErrCodeUnavailable
+	}
+	return nil
+}
+
+// DeleteLink moves an owner's link to the trash. Redirects answer 410 Gone from then on, and the link
+// can be restored until the purge job removes it after the configured retention.
+// Returns the deleted link, or ErrNotFound if the owner has no such link outside the trash.
+func DeleteLink(reqCtx context.Context, owner, shortUrl string) (*Link, error) {
+	// The link is indexed first: if the update fails, the purge job drops the entry of a link not in the trash,
+	// while a link in the trash without an entry would never be purged.
+	indexCtx, cancel := withTimeout(reqCtx)
+	start := time.Now()
+	err := storeService.redisClient.ZAddNX(indexCtx, trashKey(), redis.Z{Score: float64(time.Now().UnixMilli()), Member: shortUrl}).Err()
+	metrics.ObserveStore("index_trash", start, err)
+	cancel()
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	return UpdateLink(reqCtx, shortUrl, func(link *Link) error {
+		if link.Owner != owner || link.DeletedAt != nil {
+			return ErrNotFound
+		}
+		now := time.Now().UTC()
+		link.DeletedAt = &now
+		return nil
+	})
+}
+
+// RestoreLink takes an owner's link out of the trash.
+// Returns the restored link, or ErrNotFound if the owner has no such link in the trash.
+func RestoreLink(reqCtx context.Context, owner, shortUrl string) (*Link, error) {
+	link, err := UpdateLink(reqCtx, shortUrl, func(link *Link) error {
+		if link.Owner != owner || link.DeletedAt == nil {
+			return ErrNotFound
+		}
+		link.DeletedAt = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A failure only leaves an entry the purge job drops.
+	indexCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+	storeService.redisClient.ZRem(indexCtx, trashKey(), shortUrl)
+	return link, nil
+}
+
+// PurgeTrash removes for good the links that have been in the trash for longer than the configured
+// retention at the given time, and returns how many were removed. The code of each purged link is
+// quarantined for the configured duration, so nobody can take it over while old links still point to it.
+func PurgeTrash(reqCtx context.Context, now time.Time) (int, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.PurgeTrash")
+	cutoff := now.Add(-config.AppConfig.TrashRetention)
+
+	dueCtx, cancel := withTimeout(reqCtx)
+	start := time.Now()
+	codes, err := storeService.redisClient.ZRangeByScore(dueCtx, trashKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(cutoff.UnixMilli(), 10),
+	}).Result()
+	metrics.ObserveStore("list_trash", start, err)
+	cancel()
+	if err != nil {
+		tracing.End(span, err)
+		return 0, storeError(err)
+	}
+
+	purged := 0
+	for _, code := range codes {
+		ok, err := purgeLink(reqCtx, code, cutoff)
+		if err != nil {
+			tracing.End(span, err)
+			return purged, storeError(err)
+		}
+		if ok {
+			purged++
+		}
+	}
+	tracing.End(span, nil)
+	return purged, nil
+}
+
+// purgeLink removes a link that has been in the trash since before the cutoff, with its history and counters,
+// and quarantines its code. Index entries of links that are gone or not in the trash anymore are dropped.
+// Returns whether the link was purged.
+func purgeLink(reqCtx context.Context, shortUrl string, cutoff time.Time) (bool, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	var purged *Link
+	var event *AuditEvent
+	var eventValue string
+	stale := false
+	purge := func(tx *redis.Tx) error {
+		purged, stale = nil, false
+		value, err := tx.Get(reqCtx, key(shortUrl)).Result()
+		if errors.Is(err, redis.Nil) {
+			stale = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		link, err := decodeLink(shortUrl, value)
+		if err != nil {
+			return fmt.Errorf("decoding link %q: %w", shortUrl, err)
+		}
+		if link.DeletedAt == nil {
+			stale = true
+			return nil
+		}
+		if link.DeletedAt.After(cutoff) {
+			// Deleted again since it was indexed; its entry is still due later.
+			return nil
+		}
+		if event, eventValue, err = encodeAuditEvent(reqCtx, AuditActionPurge, link, nil); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(reqCtx, func(pipe redis.Pipeliner) error {
+			pipe.Del(reqCtx, key(shortUrl), subKey(shortUrl, versionsSuffix), subKey(shortUrl, clicksSuffix))
+			if quarantine := config.AppConfig.CodeQuarantine; quarantine > 0 {
+				pipe.Set(reqCtx, subKey(shortUrl, quarantineSuffix), link.Owner, quarantine)
+			}
+			queueAudit(reqCtx, pipe, eventValue)
+			return nil
+		})
+		purged = link
+		return err
+	}
+
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if err = storeService.redisClient.Watch(reqCtx, purge, key(shortUrl)); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	metrics.ObserveStore("purge_link", start, err)
+	if err != nil {
+		return false, err
+	}
+	if purged == nil && !stale {
+		return false, nil
+	}
+
+	// The indexes live on other slots in Cluster mode; a failure only leaves entries that are dropped later.
+	pipe := storeService.redisClient.Pipeline()
+	pipe.ZRem(reqCtx, trashKey(), shortUrl)
+	if purged != nil && purged.Owner != "" {
+		pipe.ZRem(reqCtx, ownerLinksKey(purged.Owner), shortUrl)
+	}
+	if _, err := pipe.Exec(reqCtx); err != nil {
+		slog.WarnContext(reqCtx, "Failed to clean up the indexes of a purged link", slog.String("short_url", shortUrl), slog.Any("error", err))
+	}
+	if purged == nil {
+		return false, nil
+	}
+
+	if err := InvalidateLink(reqCtx, shortUrl); err != nil {
+		slog.WarnContext(reqCtx, "Failed to publish link cache invalidation", slog.String("short_url", shortUrl), slog.Any("error", err))
+	}
+	recordAudit(reqCtx, event, eventValue)
+	return true, nil
+}
+
+// purgeTrashPeriodically runs PurgeTrash at the configured interval until ctx is canceled by Close.
+// Every replica runs it; purging a link twice is harmless, since each purge checks the link again.
+func purgeTrashPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(config.AppConfig.TrashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !storeService.connected.Load() {
+			continue
+		}
+		purged, err := PurgeTrash(ctx, time.Now())
+		switch {
+		case err != nil && ctx.Err() == nil:
+			slog.Warn("Failed to purge the trash", slog.Int("purged", purged), slog.Any("error", err))
+		case purged > 0:
+			slog.Info("Purged links from the trash", slog.Int("purged", purged))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrash(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	owner := fmt.Sprintf("trash-owner-%d", time.Now().UnixNano())
+	code := owner + "-link"
+
+	require.NoError(t, SaveLink(context.Background(), &Link{Code: code, Destination: "https://example.com", Owner: owner}))
+	_, err = DeleteLink(context.Background(), "someone-else", code)
+	assert.ErrorIs(t, err, ErrNotFound)
+	deleted, err := DeleteLink(context.Background(), owner, code)
+	require.NoError(t, err)
+	assert.NotNil(t, deleted.DeletedAt)
+	_, err = DeleteLink(context.Background(), owner, code)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Links in the trash answer as deleted, keep their code, and are only listed as such.
+	_, err = GetLink(context.Background(), code)
+	assert.ErrorIs(t, err, ErrDeleted)
+	assert.ErrorIs(t, SaveLink(context.Background(), &Link{Code: code, Destination: "https://attacker.example", Owner: "attacker"}), ErrCodeUnavailable)
+	page, err := ListLinks(context.Background(), LinkQuery{Owner: owner})
+	require.NoError(t, err)
+	assert.Empty(t, page.Links)
+	page, err = ListLinks(context.Background(), LinkQuery{Owner: owner, Status: LinkStatusDeleted})
+	require.NoError(t, err)
+	require.Len(t, page.Links, 1)
+	assert.Equal(t, code, page.Links[0].Code)
+
+	restored, err := RestoreLink(context.Background(), owner, code)
+	require.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	link, err := GetLink(context.Background(), code)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", link.Destination)
+	_, err = RestoreLink(context.Background(), owner, code)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	events, err := ListAuditEvents(context.Background(), AuditQuery{Code: code})
+	require.NoError(t, err)
+	require.Len(t, events.Events, 3)
+	assert.Equal(t, AuditActionRestore, events.Events[0].Action)
+	assert.Equal(t, AuditActionDelete, events.Events[1].Action)
+}
+
+func TestPurgeTrash(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	owner := fmt.Sprintf("purge-owner-%d", time.Now().UnixNano())
+	code := owner + "-link"
+
+	require.NoError(t, SaveLink(context.Background(), &Link{Code: code, Destination: "https://example.com", Owner: owner}))
+	_, err = DeleteLink(context.Background(), owner, code)
+	require.NoError(t, err)
+
+	// Links are kept for the retention, then purged once.
+	_, err = PurgeTrash(context.Background(), time.Now())
+	require.NoError(t, err)
+	_, err = GetLink(context.Background(), code)
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	later := time.Now().Add(config.AppConfig.TrashRetention + time.Minute)
+	purged, err := PurgeTrash(context.Background(), later)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, purged, 1)
+	_, err = GetLink(context.Background(), code)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = RestoreLink(context.Background(), owner, code)
+	assert.ErrorIs(t, err, ErrNotFound)
+	score, err := storeService.redisClient.ZScore(context.Background(), trashKey(), code).Result()
+	assert.ErrorIs(t, err, redis.Nil, "score %v", score)
+
+	events, err := ListAuditEvents(context.Background(), AuditQuery{Code: code, Action: AuditActionPurge})
+	require.NoError(t, err)
+	require.Len(t, events.Events, 1)
+	assert.Nil(t, events.Events[0].After)
+
+	// The code is quarantined after the purge.
+	assert.ErrorIs(t, SaveLink(context.Background(), &Link{Code: code, Destination: "https://attacker.example", Owner: "attacker"}), ErrCodeUnavailable)
+	require.NoError(t, storeService.redisClient.Del(context.Background(), subKey(code, quarantineSuffix)).Err())
+	assert.NoError(t, SaveLink(context.Background(), &Link{Code: code, Destination: "https://example.com/new", Owner: owner}))
+}
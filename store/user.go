@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Errors returned by user operations.
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrEmailTaken   = errors.New("email already registered")
+)
+
+// User is a registered account. Links created by a logged-in user are owned by its ID.
+type User struct {
+	ID           string    `json:"id"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	LastLoginAt  time.Time `json:"last_login_at,omitempty"`
+}
+
+// userKey returns the key of a user's profile.
+func userKey(id string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "user:" + id + "}:profile"
+}
+
+// emailKey returns the key mapping an email address to the ID of the user registered with it.
+func emailKey(email string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "email:" + email + "}:user"
+}
+
+// NormalizeEmail returns the form under which an email address is registered, so lookups ignore case and spaces.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CreateUser registers a new user, assigning its ID and timestamps.
+// Returns ErrEmailTaken if a user is already registered with the same email address.
+func CreateUser(reqCtx context.Context, user *User) error {
+	reqCtx, span := tracing.Start(reqCtx, "store.CreateUser")
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	user.ID = uuid.NewString()
+	user.Email = NormalizeEmail(user.Email)
+	user.CreatedAt, user.UpdatedAt = now, now
+
+	// Claim the email address first, so two concurrent registrations cannot both succeed.
+	start := time.Now()
+	claimed, err := storeService.redisClient.SetNX(reqCtx, emailKey(user.Email), user.ID, 0).Result()
+	if err == nil && !claimed {
+		err = ErrEmailTaken
+	} else if err == nil {
+		if err = writeUser(reqCtx, user); err != nil {
+			// Release the email address, so the registration can be retried.
+			storeService.redisClient.Del(reqCtx, emailKey(user.Email))
+		}
+	}
+	metrics.ObserveStore("create_user", start, ignore(err, ErrEmailTaken))
+	tracing.End(span, ignore(err, ErrEmailTaken))
+	if errors.Is(err, ErrEmailTaken) {
+		return err
+	}
+	return storeError(err)
+}
+
+// GetUser returns the user with the given ID, or ErrUserNotFound.
+func GetUser(reqCtx context.Context, id string) (*User, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	value, err := storeService.redisClient.Get(reqCtx, userKey(id)).Result()
+	metrics.ObserveStore("get_user", start, ignore(err, redis.Nil))
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var user User
+	if err := json.Unmarshal([]byte(value), &user); err != nil {
+		return nil, fmt.Errorf("decoding user %q: %w", id, err)
+	}
+	return &user, nil
+}
+
+// GetUserByEmail returns the user registered with the given email address, or ErrUserNotFound.
+func GetUserByEmail(reqCtx context.Context, email string) (*User, error) {
+	lookupCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	id, err := storeService.redisClient.Get(lookupCtx, emailKey(NormalizeEmail(email))).Result()
+	metrics.ObserveStore("get_user_by_email", start, ignore(err, redis.Nil))
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return GetUser(reqCtx, id)
+}
+
+// UpdateUser applies a change to a user and saves it, setting its update time.
+// The ID and email address cannot be changed.
+func UpdateUser(reqCtx context.Context, id string, change func(*User)) (*User, error) {
+	user, err := GetUser(reqCtx, id)
+	if err != nil {
+		return nil, err
+	}
+	email := user.Email
+	change(user)
+	user.ID, user.Email = id, email
+	user.UpdatedAt = time.Now().UTC()
+
+	writeCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+	start := time.Now()
+	err = writeUser(writeCtx, user)
+	metrics.ObserveStore("update_user", start, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return user, nil
+}
+
+// writeUser stores a user's profile.
+func writeUser(reqCtx context.Context, user *User) error {
+	value, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return storeService.redisClient.Set(reqCtx, userKey(user.ID), value, 0).Err()
+}
+
+// ignore returns nil if err is target, and err otherwise.
+// It keeps expected outcomes, such as a missing key, out of the error metrics and traces.
+func ignore(err, target error) error {
+	if errors.Is(err, target) {
+		return nil
+	}
+	return err
+}
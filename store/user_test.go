@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsers(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	email := fmt.Sprintf("User-%d@Example.com ", time.Now().UnixNano())
+
+	user := &User{Email: email, PasswordHash: "hash"}
+	assert.NoError(t, CreateUser(context.Background(), user))
+	assert.NotEmpty(t, user.ID)
+	assert.Equal(t, NormalizeEmail(email), user.Email)
+	assert.ErrorIs(t, CreateUser(context.Background(), &User{Email: NormalizeEmail(email)}), ErrEmailTaken)
+
+	found, err := GetUserByEmail(context.Background(), email)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+	_, err = GetUserByEmail(context.Background(), "nobody@example.com")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	// The ID and email address cannot be changed.
+	updated, err := UpdateUser(context.Background(), user.ID, func(u *User) {
+		u.ID, u.Email, u.PasswordHash = "other", "other@example.com", "new hash"
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, updated.ID)
+	assert.Equal(t, user.Email, updated.Email)
+	assert.Equal(t, "new hash", updated.PasswordHash)
+}
+
+func TestSessions(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	userID := fmt.Sprintf("session-user-%d", time.Now().UnixNano())
+
+	token, session, err := CreateSession(context.Background(), userID, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, session.UserID)
+	other, _, err := CreateSession(context.Background(), userID, time.Hour)
+	assert.NoError(t, err)
+	third, _, err := CreateSession(context.Background(), userID, time.Hour)
+	assert.NoError(t, err)
+
+	// Only a hash of the token is stored.
+	assert.NotContains(t, sessionKey(token), token)
+	loaded, err := GetSession(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, loaded.UserID)
+
+	assert.NoError(t, DeleteSession(context.Background(), token))
+	_, err = GetSession(context.Background(), token)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// Ending the other sessions keeps the current one.
+	assert.NoError(t, DeleteUserSessions(context.Background(), userID, other))
+	_, err = GetSession(context.Background(), other)
+	assert.NoError(t, err)
+	_, err = GetSession(context.Background(), third)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/redis/go-redis/v9"
+)
+
+// versionsSuffix names the list of a link's versions, newest first, stored next to the link.
+const versionsSuffix = "versions"
+
+// ErrVersionNotFound is returned for versions a link never had or whose history was trimmed.
+var ErrVersionNotFound = errors.New("version not found")
+
+// LinkVersion is the state of a link after one of its writes, as kept in its history.
+type LinkVersion struct {
+	Version int       `json:"version"`
+	SavedAt time.Time `json:"saved_at"`
+	Actor             // Who wrote the version, without their IP address.
+	Link    *Link     `json:"link"`
+}
+
+// Restore sets the destination and settings of a link back to those of the version.
+// The link keeps its owner, timestamps and flags, so a rollback cannot undo a transfer or re-enable a link.
+func (v *LinkVersion) Restore(link *Link) {
+	link.Destination = v.Link.Destination
+	link.Title = v.Link.Title
+	link.Tags = slices.Clone(v.Link.Tags)
+	link.Folder = v.Link.Folder
+	link.ExpiresAt = nil
+	if v.Link.ExpiresAt != nil {
+		expiresAt := *v.Link.ExpiresAt
+		link.ExpiresAt = &expiresAt
+	}
+	link.RedirectCode = v.Link.RedirectCode
+}
+
+// queueLinkVersion queues the commands that add a link, as just written, to its history.
+// The history keeps the configured number of versions and expires together with the link.
+func queueLinkVersion(reqCtx context.Context, pipe redis.Pipeliner, link *Link, now time.Time) {
+	maxVersions := config.AppConfig.LinkMaxVersions
+	if maxVersions == 0 {
+		return
+	}
+	actor := actorFrom(reqCtx)
+	actor.ClientIP = ""
+	value, err := json.Marshal(&LinkVersion{Version: link.Version, SavedAt: now, Actor: actor, Link: link})
+	if err != nil {
+		return
+	}
+
+	versionsKey := subKey(link.Code, versionsSuffix)
+	pipe.LPush(reqCtx, versionsKey, value)
+	pipe.LTrim(reqCtx, versionsKey, 0, int64(maxVersions-1))
+	if ttl := link.ttl(now); ttl > 0 {
+		pipe.Expire(reqCtx, versionsKey, ttl)
+	} else {
+		pipe.Persist(reqCtx, versionsKey)
+	}
+}
+
+// ListLinkVersions returns the history of an owner's link, newest first, or ErrNotFound if the owner
+// has no link under the short code. Expired links have a history too, so they can be rolled back.
+// Links written before versions were kept have no history until their next change.
+func ListLinkVersions(reqCtx context.Context, owner, shortUrl string) ([]*LinkVersion, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.ListLinkVersions", tracing.ShortCode(shortUrl))
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	var linkCmd *redis.StringCmd
+	var versionsCmd *redis.StringSliceCmd
+	_, err := storeService.redisClient.Pipelined(reqCtx, func(pipe redis.Pipeliner) error {
+		linkCmd = pipe.Get(reqCtx, key(shortUrl))
+		versionsCmd = pipe.LRange(reqCtx, subKey(shortUrl, versionsSuffix), 0, -1)
+		return nil
+	})
+	metrics.ObserveStore("list_link_versions", start, ignore(err, redis.Nil))
+	tracing.End(span, ignore(err, redis.Nil))
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, storeError(err)
+	}
+	value, err := linkCmd.Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	link, err := decodeLink(shortUrl, value)
+	if err != nil {
+		return nil, fmt.Errorf("decoding link %q: %w", shortUrl, err)
+	}
+	if link.Owner != owner {
+		return nil, ErrNotFound
+	}
+
+	versions := make([]*LinkVersion, 0, len(versionsCmd.Val()))
+	for _, value := range versionsCmd.Val() {
+		var version LinkVersion
+		if err := json.Unmarshal([]byte(value), &version); err != nil {
+			return nil, fmt.Errorf("decoding version of link %q: %w", shortUrl, err)
+		}
+		version.Link.Code = shortUrl
+		versions = append(versions, &version)
+	}
+	return versions, nil
+}
+
+// RollbackLink restores the destination and settings of an owner's link to those of one of its versions,
+// as a new version. A folder deleted since the version was written is not restored.
+// Returns the updated link, ErrNotFound if the owner has no link under the short code, or ErrVersionNotFound.
+func RollbackLink(reqCtx context.Context, owner, shortUrl string, version int) (*Link, error) {
+	versions, err := ListLinkVersions(reqCtx, owner, shortUrl)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(versions, func(v *LinkVersion) bool { return v.Version == version })
+	if i < 0 {
+		return nil, ErrVersionNotFound
+	}
+	target := versions[i]
+	if target.Link.Folder != "" {
+		if _, err := GetFolder(reqCtx, owner, target.Link.Folder); errors.Is(err, ErrFolderNotFound) {
+			target.Link.Folder = ""
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return UpdateLink(reqCtx, shortUrl, func(link *Link) error {
+		if link.Owner != owner {
+			return ErrNotFound
+		}
+		target.Restore(link)
+		return nil
+	})
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkVersions(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	ctx := WithActor(context.Background(), Actor{Type: ActorUser, ID: "version-owner", ClientIP: "203.0.113.7"})
+	code := fmt.Sprintf("versioned-%d", time.Now().UnixNano())
+
+	require.NoError(t, SaveLink(ctx, &Link{Code: code, Destination: "https://example.com/v1", Owner: "version-owner", Title: "First"}))
+	_, err = UpdateLink(ctx, code, func(l *Link) error {
+		l.Destination, l.Title, l.RedirectCode = "https://example.com/v2", "Second", 302
+		l.AddTags("broken")
+		return nil
+	})
+	require.NoError(t, err)
+
+	versions, err := ListLinkVersions(context.Background(), "version-owner", code)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, 2, versions[0].Version)
+	assert.Equal(t, "https://example.com/v2", versions[0].Link.Destination)
+	assert.Equal(t, 1, versions[1].Version)
+	assert.Equal(t, "https://example.com/v1", versions[1].Link.Destination)
+	// Versions record who wrote them, but not from where.
+	assert.Equal(t, Actor{Type: ActorUser, ID: "version-owner"}, versions[1].Actor)
+
+	_, err = ListLinkVersions(context.Background(), "someone-else", code)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = RollbackLink(context.Background(), "someone-else", code, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = RollbackLink(context.Background(), "version-owner", code, 7)
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+
+	// A rollback is a new version, and is visible to redirects right away.
+	_, err = GetLink(context.Background(), code)
+	require.NoError(t, err)
+	link, err := RollbackLink(ctx, "version-owner", code, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, link.Version)
+	assert.Equal(t, "https://example.com/v1", link.Destination)
+	assert.Equal(t, "First", link.Title)
+	assert.Empty(t, link.Tags)
+	assert.Zero(t, link.RedirectCode)
+	current, err := GetLink(context.Background(), code)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/v1", current.Destination)
+
+	versions, err = ListLinkVersions(context.Background(), "version-owner", code)
+	require.NoError(t, err)
+	assert.Len(t, versions, 3)
+
+	// A link replacing an expired one starts a new history.
+	_, err = UpdateLink(ctx, code, func(l *Link) error {
+		expiresAt := time.Now().Add(-time.Minute)
+		l.ExpiresAt = &expiresAt
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, SaveLink(ctx, &Link{Code: code, Destination: "https://example.com/new", Owner: "version-owner"}))
+	versions, err = ListLinkVersions(context.Background(), "version-owner", code)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, 1, versions[0].Version)
+}
+
+func TestLinkVersionsTrimmed(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	require.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	defer func(maxVersions int) { config.AppConfig.LinkMaxVersions = maxVersions }(config.AppConfig.LinkMaxVersions)
+	config.AppConfig.LinkMaxVersions = 2
+	code := fmt.Sprintf("trimmed-%d", time.Now().UnixNano())
+
+	require.NoError(t, SaveLink(context.Background(), &Link{Code: code, Destination: "https://example.com/1", Owner: "trim-owner"}))
+	for i := 2; i <= 4; i++ {
+		_, err := UpdateLink(context.Background(), code, func(l *Link) error {
+			l.Destination = fmt.Sprintf("https://example.com/%d", i)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	versions, err := ListLinkVersions(context.Background(), "trim-owner", code)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, 4, versions[0].Version)
+	assert.Equal(t, 3, versions[1].Version)
+	_, err = RollbackLink(context.Background(), "trim-owner", code, 1)
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
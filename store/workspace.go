@@ -0,0 +1,299 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/drunkleen/go-url-shortner/metrics"
+	"github.com/drunkleen/go-url-shortner/tracing"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Errors returned by workspace operations.
+var (
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	ErrNotMember         = errors.New("not a member of the workspace")
+	ErrLastOwner         = errors.New("a workspace must keep at least one owner")
+)
+
+// Workspace is a team sharing links. Links and folders created in a workspace are owned by its ID,
+// and its members access them according to their role.
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Member is a user's role in a workspace.
+type Member struct {
+	UserID string `json:"user_id"`
+	Role   Role   `json:"role"`
+}
+
+// Membership is a workspace a user belongs to, and the user's role in it.
+type Membership struct {
+	Workspace *Workspace `json:"workspace"`
+	Role      Role       `json:"role"`
+}
+
+// workspaceKey returns the key of a workspace's profile.
+func workspaceKey(id string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "workspace:" + id + "}:profile"
+}
+
+// workspaceMembersKey returns the key of the hash mapping the IDs of a workspace's members to their role.
+// It is the source of truth for memberships, and shares the hash tag of the workspace's profile.
+func workspaceMembersKey(id string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "workspace:" + id + "}:members"
+}
+
+// userWorkspacesKey returns the key of the set of the workspaces a user belongs to, used to list them.
+func userWorkspacesKey(userID string) string {
+	return "{" + config.AppConfig.RedisKeyPrefix + "user:" + userID + "}:workspaces"
+}
+
+// CreateWorkspace stores a new workspace, assigning its ID and timestamps, with its creator as its owner.
+func CreateWorkspace(reqCtx context.Context, workspace *Workspace) error {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	workspace.ID = uuid.NewString()
+	workspace.CreatedAt, workspace.UpdatedAt = now, now
+	value, err := json.Marshal(workspace)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	_, err = storeService.redisClient.TxPipelined(reqCtx, func(pipe redis.Pipeliner) error {
+		pipe.Set(reqCtx, workspaceKey(workspace.ID), value, 0)
+		pipe.HSet(reqCtx, workspaceMembersKey(workspace.ID), workspace.CreatedBy, string(RoleOwner))
+		return nil
+	})
+	if err == nil {
+		err = storeService.redisClient.SAdd(reqCtx, userWorkspacesKey(workspace.CreatedBy), workspace.ID).Err()
+	}
+	metrics.ObserveStore("create_workspace", start, err)
+	return storeError(err)
+}
+
+// GetWorkspace returns a workspace, or ErrWorkspaceNotFound.
+func GetWorkspace(reqCtx context.Context, id string) (*Workspace, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	value, err := storeService.redisClient.Get(reqCtx, workspaceKey(id)).Result()
+	metrics.ObserveStore("get_workspace", start, ignore(err, redis.Nil))
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var workspace Workspace
+	if err := json.Unmarshal([]byte(value), &workspace); err != nil {
+		return nil, fmt.Errorf("decoding workspace %q: %w", id, err)
+	}
+	return &workspace, nil
+}
+
+// UpdateWorkspace applies a change to a workspace and saves it, setting its update time.
+// Its ID, creator and creation time cannot be changed.
+func UpdateWorkspace(reqCtx context.Context, id string, change func(*Workspace)) (*Workspace, error) {
+	workspace, err := GetWorkspace(reqCtx, id)
+	if err != nil {
+		return nil, err
+	}
+	original := *workspace
+	change(workspace)
+	workspace.ID, workspace.CreatedBy, workspace.CreatedAt = original.ID, original.CreatedBy, original.CreatedAt
+	workspace.UpdatedAt = time.Now().UTC()
+	value, err := json.Marshal(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	writeCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+	start := time.Now()
+	err = storeService.redisClient.Set(writeCtx, workspaceKey(id), value, 0).Err()
+	metrics.ObserveStore("update_workspace", start, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return workspace, nil
+}
+
+// MemberRole returns a user's role in a workspace, or ErrNotMember.
+// Unknown workspaces have no members, so they also return ErrNotMember.
+func MemberRole(reqCtx context.Context, workspaceID, userID string) (Role, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	role, err := storeService.redisClient.HGet(reqCtx, workspaceMembersKey(workspaceID), userID).Result()
+	metrics.ObserveStore("get_member_role", start, ignore(err, redis.Nil))
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotMember
+	}
+	if err != nil {
+		return "", storeError(err)
+	}
+	return Role(role), nil
+}
+
+// ListMembers returns the members of a workspace, the most privileged first.
+func ListMembers(reqCtx context.Context, workspaceID string) ([]Member, error) {
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	start := time.Now()
+	roles, err := storeService.redisClient.HGetAll(reqCtx, workspaceMembersKey(workspaceID)).Result()
+	metrics.ObserveStore("list_members", start, err)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return sortedMembers(roles), nil
+}
+
+// sortedMembers turns a members hash into members sorted by decreasing role, then by user ID.
+func sortedMembers(roles map[string]string) []Member {
+	members := make([]Member, 0, len(roles))
+	for userID, role := range roles {
+		members = append(members, Member{UserID: userID, Role: Role(role)})
+	}
+	slices.SortFunc(members, func(a, b Member) int {
+		return cmp.Or(cmp.Compare(roleRanks[b.Role], roleRanks[a.Role]), cmp.Compare(a.UserID, b.UserID))
+	})
+	return members
+}
+
+// ListUserWorkspaces returns the workspaces a user belongs to, with the user's role in each, sorted by name.
+// Workspaces the user has left or that are gone are removed from the user's list along the way.
+func ListUserWorkspaces(reqCtx context.Context, userID string) ([]Membership, error) {
+	listCtx, cancel := withTimeout(reqCtx)
+	start := time.Now()
+	ids, err := storeService.redisClient.SMembers(listCtx, userWorkspacesKey(userID)).Result()
+	metrics.ObserveStore("list_user_workspaces", start, err)
+	cancel()
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var memberships []Membership
+	var stale []any
+	for _, id := range ids {
+		role, err := MemberRole(reqCtx, id, userID)
+		var workspace *Workspace
+		if err == nil {
+			workspace, err = GetWorkspace(reqCtx, id)
+		}
+		if errors.Is(err, ErrNotMember) || errors.Is(err, ErrWorkspaceNotFound) {
+			stale = append(stale, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, Membership{Workspace: workspace, Role: role})
+	}
+
+	// Clean up the list; a failure only leaves entries that are skipped again next time.
+	if len(stale) > 0 {
+		cleanupCtx, cancel := withTimeout(reqCtx)
+		storeService.redisClient.SRem(cleanupCtx, userWorkspacesKey(userID), stale...)
+		cancel()
+	}
+	slices.SortFunc(memberships, func(a, b Membership) int {
+		return cmp.Or(cmp.Compare(a.Workspace.Name, b.Workspace.Name), cmp.Compare(a.Workspace.ID, b.Workspace.ID))
+	})
+	return memberships, nil
+}
+
+// ChangeMember changes a user's role in a workspace. The change function receives the user's current role,
+// empty if the user is not a member, and returns the new one, empty to remove the user from the workspace;
+// an error it returns is returned as is and leaves the membership unchanged.
+// The change is applied atomically and refused with ErrLastOwner if it would leave the workspace without an owner.
+// It returns the new role.
+func ChangeMember(reqCtx context.Context, workspaceID, userID string, change func(current Role) (Role, error)) (Role, error) {
+	reqCtx, span := tracing.Start(reqCtx, "store.ChangeMember")
+	reqCtx, cancel := withTimeout(reqCtx)
+	defer cancel()
+
+	membersKey := workspaceMembersKey(workspaceID)
+	var role Role
+	var changeErr error
+	update := func(tx *redis.Tx) error {
+		roles, err := tx.HGetAll(reqCtx, membersKey).Result()
+		if err != nil {
+			return err
+		}
+		current := Role(roles[userID])
+		if role, changeErr = change(current); changeErr != nil {
+			return changeErr
+		}
+		if current == RoleOwner && role != RoleOwner && countRole(roles, RoleOwner) == 1 {
+			changeErr = ErrLastOwner
+			return changeErr
+		}
+
+		_, err = tx.TxPipelined(reqCtx, func(pipe redis.Pipeliner) error {
+			if role == "" {
+				pipe.HDel(reqCtx, membersKey, userID)
+			} else {
+				pipe.HSet(reqCtx, membersKey, userID, string(role))
+			}
+			return nil
+		})
+		return err
+	}
+
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if err = storeService.redisClient.Watch(reqCtx, update, membersKey); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if changeErr != nil {
+		tracing.End(span, nil)
+		return "", changeErr
+	}
+	if err == nil {
+		// The user's list of workspaces lives on another slot in Cluster mode, so it cannot be part of the transaction.
+		if role == "" {
+			err = storeService.redisClient.SRem(reqCtx, userWorkspacesKey(userID), workspaceID).Err()
+		} else {
+			err = storeService.redisClient.SAdd(reqCtx, userWorkspacesKey(userID), workspaceID).Err()
+		}
+	}
+	metrics.ObserveStore("change_member", start, err)
+	tracing.End(span, err)
+	if err != nil {
+		return "", storeError(err)
+	}
+	return role, nil
+}
+
+// countRole returns the number of members of a members hash holding a role.
+func countRole(roles map[string]string, role Role) int {
+	count := 0
+	for _, r := range roles {
+		if Role(r) == role {
+			count++
+		}
+	}
+	return count
+}
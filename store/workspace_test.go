@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drunkleen/go-url-shortner/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspaces(t *testing.T) {
+	opts, err := redisOptions(config.AppConfig)
+	assert.NoError(t, err)
+	storeService.redisClient = redis.NewClient(opts)
+	run := time.Now().UnixNano()
+	owner, editor := fmt.Sprintf("ws-owner-%d", run), fmt.Sprintf("ws-editor-%d", run)
+
+	workspace := &Workspace{Name: "Marketing", CreatedBy: owner}
+	assert.NoError(t, CreateWorkspace(context.Background(), workspace))
+	role, err := MemberRole(context.Background(), workspace.ID, owner)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleOwner, role)
+	_, err = MemberRole(context.Background(), workspace.ID, editor)
+	assert.ErrorIs(t, err, ErrNotMember)
+
+	// Invitations are single use, and do not lower the role of existing members.
+	token, err := CreateInvitation(context.Background(), &Invitation{WorkspaceID: workspace.ID, Role: RoleEditor, InvitedBy: owner}, time.Hour)
+	assert.NoError(t, err)
+	membership, err := AcceptInvitation(context.Background(), token, editor)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleEditor, membership.Role)
+	assert.Equal(t, "Marketing", membership.Workspace.Name)
+	_, err = AcceptInvitation(context.Background(), token, editor)
+	assert.ErrorIs(t, err, ErrInvitationNotFound)
+	token, err = CreateInvitation(context.Background(), &Invitation{WorkspaceID: workspace.ID, Role: RoleViewer, InvitedBy: owner}, time.Hour)
+	assert.NoError(t, err)
+	membership, err = AcceptInvitation(context.Background(), token, owner)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleOwner, membership.Role)
+
+	members, err := ListMembers(context.Background(), workspace.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []Member{{UserID: owner, Role: RoleOwner}, {UserID: editor, Role: RoleEditor}}, members)
+	memberships, err := ListUserWorkspaces(context.Background(), editor)
+	assert.NoError(t, err)
+	assert.Len(t, memberships, 1)
+
+	// The last owner cannot leave or be demoted.
+	setRole := func(r Role) func(Role) (Role, error) { return func(Role) (Role, error) { return r, nil } }
+	_, err = ChangeMember(context.Background(), workspace.ID, owner, setRole(RoleAdmin))
+	assert.ErrorIs(t, err, ErrLastOwner)
+	_, err = ChangeMember(context.Background(), workspace.ID, editor, setRole(RoleOwner))
+	assert.NoError(t, err)
+	_, err = ChangeMember(context.Background(), workspace.ID, owner, setRole(""))
+	assert.NoError(t, err)
+	memberships, err = ListUserWorkspaces(context.Background(), owner)
+	assert.NoError(t, err)
+	assert.Empty(t, memberships)
+
+	renamed, err := UpdateWorkspace(context.Background(), workspace.ID, func(w *Workspace) { w.Name, w.CreatedBy = "Growth", editor })
+	assert.NoError(t, err)
+	assert.Equal(t, "Growth", renamed.Name)
+	assert.Equal(t, owner, renamed.CreatedBy)
+}
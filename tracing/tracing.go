@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies this service in exported traces.
+const ServiceName = "url-shortener"
+
+// tracerName is the instrumentation scope used for spans created by this application.
+const tracerName = "github.com/drunkleen/go-url-shortner"
+
+// Exporter names accepted by Setup.
+const (
+	ExporterNone   = "none"   // Tracing disabled; spans are not recorded.
+	ExporterOTLP   = "otlp"   // Export spans over OTLP/HTTP to a collector.
+	ExporterStdout = "stdout" // Write spans as JSON to stdout or a file, for testing.
+)
+
+// ShutdownFunc flushes pending spans and releases the exporter.
+type ShutdownFunc func(context.Context) error
+
+// Options configures the trace exporter.
+type Options struct {
+	Exporter string // One of ExporterNone, ExporterOTLP or ExporterStdout.
+	Endpoint string // The OTLP/HTTP collector endpoint (host:port), used by the otlp exporter.
+	Insecure bool   // Whether to talk to the OTLP collector over plain HTTP.
+	File     string // The file the stdout exporter writes to; empty writes to stdout.
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator.
+// It returns a function that must be called on shutdown to flush pending spans.
+// The propagator is installed even when tracing is disabled, so incoming traceparent
+// headers are still forwarded.
+func Setup(ctx context.Context, opts Options) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// Pick the exporter based on the configuration.
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	switch strings.ToLower(opts.Exporter) {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		clientOpts := []otlptracehttp.Option{}
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		otlpExporter, err := otlptracehttp.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+		}
+		exporter = otlpExporter
+	case ExporterStdout:
+		var w io.Writer = os.Stdout
+		if opts.File != "" {
+			file, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return nil, fmt.Errorf("opening trace file: %w", err)
+			}
+			w, closer = file, file
+		}
+		stdoutExporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, fmt.Errorf("creating stdout exporter: %w", err)
+		}
+		exporter = stdoutExporter
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, expected none, otlp or stdout", opts.Exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("creating trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// Start starts a span with the given name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// ShortCode returns the span attribute identifying a short code.
+func ShortCode(code string) attribute.KeyValue {
+	return attribute.String("short_code", code)
+}
+
+// End records err on the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
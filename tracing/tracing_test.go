@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupStdoutExporterWritesSpans(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterStdout, File: file})
+	assert.NoError(t, err)
+
+	_, span := Start(context.Background(), "store.RetrieveInitialUrl", ShortCode("abc12345"))
+	End(span, errors.New("connection refused"))
+	assert.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"store.RetrieveInitialUrl"`)
+	assert.Contains(t, string(data), `"abc12345"`)
+	assert.Contains(t, string(data), "connection refused")
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Options{Exporter: "zipkin"})
+	assert.Error(t, err)
+
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterNone})
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}